@@ -0,0 +1,577 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How long a login to the admin dashboard is valid
+const adminSessionDuration = 12 * time.Hour
+
+type TemplateDataAdmin struct {
+	TemplateDataPage
+
+	// The postings on the current page and the number of postings on
+	// all pages
+	Postings []Posting
+	Count    int
+
+	// Links to the previous and next page, if any
+	PrevURL string
+	NextURL string
+
+	// The search term, selected state and sort order
+	Filter postingFilter
+
+	// The moderation states with their number of postings
+	States []AdminState
+
+	// Token to be sent with every form to protect against CSRF
+	CSRFToken string
+}
+
+type AdminState struct {
+	Name     string
+	Label    string
+	Count    int
+	Selected bool
+	URL      string
+}
+
+// adminOnly wraps handlers of the admin dashboard; requests without a
+// valid admin session are redirected to the login page, POST requests
+// must carry the CSRF token of the session
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminPassword == "" {
+			handler404(w, r)
+			return
+		}
+
+		session, err := sessionStore.Get(r, "s")
+		if err != nil {
+			log.Printf("error retrieving session: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		until, _ := session.Values["admin_until"].(int64)
+		if time.Now().Unix() > until {
+			http.Redirect(w, r, config.URL+"/admin/login", http.StatusFound)
+			return
+		}
+
+		if r.Method == "POST" {
+			csrfToken, _ := session.Values["csrf_token"].(string)
+			if csrfToken == "" || subtle.ConstantTimeCompare([]byte(csrfToken), []byte(r.FormValue("csrf-token"))) != 1 {
+				log.Printf("got invalid CSRF token for admin request %q\n", r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+func handlerAdminLogin(w http.ResponseWriter, r *http.Request) {
+	if config.AdminPassword == "" {
+		handler404(w, r)
+		return
+	}
+
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	lang := requestLang(r)
+
+	tmplData := TemplateDataPage{
+		PageTitle:  tr(lang, "Moderation"),
+		TitleText:  config.TitleText,
+		FooterText: template.HTML(config.FooterText),
+		Version:    Version,
+		Lang:       lang,
+	}
+
+	if r.Method == "POST" {
+		ip := clientIP(r)
+
+		if exceeded, retryAfter := tokenRateLimiter.exceeded(ip); exceeded {
+			log.Printf("admin login rejected, too many failed attempts from %q\n", ip)
+			handler429(w, r, retryAfter)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Printf("error parsing form: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(config.AdminPassword), []byte(r.FormValue("password"))) != 1 {
+			log.Printf("failed admin login attempt from %q\n", ip)
+			tokenRateLimiter.add(ip)
+			tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Falsches Passwort."))
+			goto EXEC_TMPL
+		}
+
+		csrfToken, err := generateToken(30)
+		if err != nil {
+			log.Printf("error generating CSRF token: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		session.Values["admin_until"] = time.Now().Add(adminSessionDuration).Unix()
+		session.Values["csrf_token"] = csrfToken
+		if err := session.Save(r, w); err != nil {
+			log.Printf("error saving session: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, config.URL+"/admin", http.StatusFound)
+		return
+	}
+
+EXEC_TMPL:
+
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "admin-login", tmplData); err != nil {
+		log.Printf("error executing template: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func handlerAdminLogout(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	delete(session.Values, "admin_until")
+	delete(session.Values, "csrf_token")
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, config.URL, http.StatusFound)
+}
+
+func handlerAdminDashboard(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	filter := parseAdminFilter(r.URL.Query())
+
+	cursor, err := parsePageCursor(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	page, err := listPostings(filter, cursor, config.PageSize)
+	if err != nil {
+		log.Printf("error reading postings from database: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	lang := requestLang(r)
+
+	tmplData := TemplateDataAdmin{
+		TemplateDataPage: TemplateDataPage{
+			PageTitle:  tr(lang, "Moderation"),
+			TitleText:  config.TitleText,
+			FooterText: template.HTML(config.FooterText),
+			Version:    Version,
+			Lang:       lang,
+		},
+		Postings: page.Postings,
+		Filter:   filter,
+	}
+
+	tmplData.CSRFToken, _ = session.Values["csrf_token"].(string)
+
+	for _, state := range postingStates {
+		count, err := countPostings(filter.withState(state.Name))
+		if err != nil {
+			log.Printf("error counting postings: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if state.Name == filter.State {
+			tmplData.Count = count
+		}
+
+		tmplData.States = append(tmplData.States, AdminState{
+			Name:     state.Name,
+			Label:    state.Label,
+			Count:    count,
+			Selected: state.Name == filter.State,
+			URL:      filter.withState(state.Name).URL(),
+		})
+	}
+
+	if page.Prev != nil {
+		tmplData.PrevURL = page.Prev.URL(filter)
+	}
+	if page.Next != nil {
+		tmplData.NextURL = page.Next.URL(filter)
+	}
+
+	for _, flash := range session.Flashes() {
+		tmplData.FlashMessages = append(tmplData.FlashMessages, flash.(string))
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "admin", tmplData); err != nil {
+		log.Printf("error executing template: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// handlerAdminPosting forwards to the preview or the edit form of a
+// posting, which are authorized by the posting's admin token
+func handlerAdminPosting(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	uuid := vars["uuid"]
+
+	action := "preview"
+	if vars["action"] == "edit" {
+		action = "admin"
+	}
+
+	var adminToken string
+
+	row := db.QueryRow("SELECT admin_token FROM postings WHERE uuid = ?", uuid)
+
+	if err := row.Scan(&adminToken); err != nil {
+		if err == sql.ErrNoRows {
+			handler404(w, r)
+			return
+		} else {
+			log.Printf("error sql with uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s/%s/%s/%s", config.URL, uuid, adminToken, action), http.StatusFound)
+}
+
+// The moderation actions with the statement executed for them and the
+// resulting flash message
+var adminActions = map[string]struct {
+	Query string
+	Flash string
+}{
+	"approve": {`
+UPDATE postings
+SET
+    verified = 1,
+    rejected = 0,
+    last_verified_at = CURRENT_TIMESTAMP,
+    reverify_requested_at = NULL
+WHERE uuid = ?
+    AND deleted = 0`,
+		"Angebot freigeschalten."},
+	"restore": {`
+UPDATE postings
+SET
+    deleted = 0,
+    deleted_at = NULL
+WHERE uuid = ?
+    AND deleted = 1`,
+		"Angebot wiederhergestellt."},
+	"purge": {`
+DELETE FROM postings
+WHERE uuid = ?`,
+		"Angebot endgültig gelöscht."},
+}
+
+func handlerAdminAction(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	uuid := vars["uuid"]
+	lang := requestLang(r)
+
+	if vars["action"] == "reject" {
+		// Rejecting needs a reason and notifies the author, so it's
+		// not a plain statement
+		reason := r.FormValue("reason")
+
+		if reason == "" {
+			session.AddFlash(tr(lang, "Zum Ablehnen ist eine Begründung erforderlich."))
+		} else if err := rejectPosting(uuid, reason); errors.Is(err, sql.ErrNoRows) {
+			session.AddFlash(tr(lang, "Aktion für dieses Angebot nicht möglich."))
+		} else if err != nil {
+			log.Printf("error rejecting posting with uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else {
+			log.Printf("admin action %q for posting with uuid %q\n", vars["action"], uuid)
+			session.AddFlash(tr(lang, "Angebot abgelehnt."))
+		}
+	} else {
+		action, ok := adminActions[vars["action"]]
+		if !ok {
+			handler404(w, r)
+			return
+		}
+
+		result, err := db.Exec(action.Query, uuid)
+		if err != nil {
+			log.Printf("error executing admin action %q for posting with uuid %q: %v\n", vars["action"], uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("admin action %q for posting with uuid %q\n", vars["action"], uuid)
+			session.AddFlash(tr(lang, action.Flash))
+
+			if vars["action"] == "purge" {
+				// The attachments went with the posting, their files
+				// are left over
+				if _, err := sweepAttachmentDir(); err != nil {
+					log.Printf("error removing files of purged attachments: %v\n", err)
+				}
+			}
+		} else {
+			session.AddFlash(tr(lang, "Aktion für dieses Angebot nicht möglich."))
+		}
+	}
+
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Return to the listing the action was triggered from
+	redirect := r.FormValue("redirect")
+	if !strings.HasPrefix(redirect, "/admin") {
+		redirect = "/admin"
+	}
+
+	http.Redirect(w, r, config.URL+redirect, http.StatusFound)
+}
+
+// rejectPosting unpublishes the posting and mails the reason to the
+// author together with the link to edit and resubmit it; the verify
+// token is replaced so that only the admins can publish the posting
+func rejectPosting(uuid, reason string) error {
+	verifyToken, err := generateToken(30)
+	if err != nil {
+		return err
+	}
+
+	var email, title, adminToken, lang string
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+UPDATE postings
+SET
+    verified = 0,
+    rejected = 1,
+    rejected_at = CURRENT_TIMESTAMP,
+    reject_reason = ?,
+    verify_token = ?
+WHERE uuid = ?
+    AND deleted = 0
+RETURNING email, title, admin_token, language`,
+		reason, verifyToken, uuid)
+	if err := row.Scan(&email, &title, &adminToken, &lang); err != nil {
+		return err
+	}
+
+	if err := queueMail(tx, []string{email}, lang, "mail-user-rejected.tmpl", struct {
+		mailData
+		Reason string
+	}{
+		mailData: newMailData(email, uuid, title, adminToken, verifyToken),
+		Reason:   reason,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
+}
+
+// resubmitPosting marks a rejected posting as waiting for verification
+// again and notifies the admins
+func resubmitPosting(uuid, email, title, adminToken, verifyToken string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+UPDATE postings
+SET
+    rejected = 0,
+    reject_reason = ''
+WHERE uuid = ?`,
+		uuid); err != nil {
+		return err
+	}
+
+	if err := queueMail(tx, []string{config.AdminEmail}, defaultLanguage, "mail-admin.tmpl", newMailData(email, uuid, title, adminToken, verifyToken)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
+}
+
+type TemplateDataOutbox struct {
+	TemplateDataPage
+
+	// The mails not sent yet, the dead ones first
+	Mails []outboxMail
+
+	// Token to be sent with every form to protect against CSRF
+	CSRFToken string
+}
+
+// handlerAdminOutbox lists the mails waiting to be sent and those given
+// up on after `mail_max_attempts` attempts
+func handlerAdminOutbox(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	mails, err := listOutbox()
+	if err != nil {
+		log.Printf("error reading outbox from database: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	lang := requestLang(r)
+
+	tmplData := TemplateDataOutbox{
+		TemplateDataPage: TemplateDataPage{
+			PageTitle:  tr(lang, "Postausgang"),
+			TitleText:  config.TitleText,
+			FooterText: template.HTML(config.FooterText),
+			Version:    Version,
+			Lang:       lang,
+		},
+		Mails: mails,
+	}
+
+	tmplData.CSRFToken, _ = session.Values["csrf_token"].(string)
+
+	for _, flash := range session.Flashes() {
+		tmplData.FlashMessages = append(tmplData.FlashMessages, flash.(string))
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "admin-outbox", tmplData); err != nil {
+		log.Printf("error executing template: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func handlerAdminOutboxAction(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		handler404(w, r)
+		return
+	}
+
+	flash := "E-Mail wird erneut gesendet."
+	action := resendMail
+	if vars["action"] == "discard" {
+		flash = "E-Mail verworfen."
+		action = discardMail
+	}
+
+	if err := action(id); errors.Is(err, sql.ErrNoRows) {
+		session.AddFlash(tr(requestLang(r), "Aktion für diese E-Mail nicht möglich."))
+	} else if err != nil {
+		log.Printf("error executing outbox action %q for mail %d: %v\n", vars["action"], id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	} else {
+		log.Printf("outbox action %q for mail %d\n", vars["action"], id)
+		session.AddFlash(tr(requestLang(r), flash))
+	}
+
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, config.URL+"/admin/outbox", http.StatusFound)
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Maximum number of postings per page in the API
+const apiMaxLimit = 100
+
+// apiPosting is the public representation of a posting in the API; the
+// author's address is not public, contact_url links the contact form
+type apiPosting struct {
+	UUID           string    `json:"uuid"`
+	URL            string    `json:"url"`
+	ContactURL     string    `json:"contact_url"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastUpdatedAt  time.Time `json:"last_updated_at"`
+	Title          string    `json:"title"`
+	Institute      string    `json:"institute"`
+	Advisor        string    `json:"advisor"`
+	Supervisor     string    `json:"supervisor"`
+	Audience       string    `json:"audience"`
+	Category       string    `json:"category"`
+	Type           string    `json:"type"`
+	Degree         string    `json:"degree"`
+	Start          string    `json:"start"`
+	RequiredMonths int       `json:"required_months"`
+	RequiredEffort string    `json:"required_effort"`
+	Text           string    `json:"text"`
+	ExpiresOn      string    `json:"expires_on,omitempty"`
+	Language       string    `json:"language"`
+
+	Translation *apiTranslation `json:"translation,omitempty"`
+}
+
+// apiTranslation is the title and text of a posting in a second language
+type apiTranslation struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+}
+
+func newAPIPosting(p Posting) apiPosting {
+	var translation *apiTranslation
+	if p.Translation.Language != "" {
+		translation = &apiTranslation{
+			Language: p.Translation.Language,
+			Title:    p.Translation.Title,
+			Text:     p.Translation.Text,
+		}
+	}
+
+	return apiPosting{
+		UUID:           p.UUID,
+		URL:            config.URL + "/" + p.UUID,
+		ContactURL:     p.ContactURL(),
+		CreatedAt:      p.CreatedAt,
+		LastUpdatedAt:  p.LastUpdatedAt,
+		Title:          p.Title,
+		Institute:      p.Institute,
+		Advisor:        p.Advisor,
+		Supervisor:     p.Supervisor,
+		Audience:       p.Audience,
+		Category:       p.Category,
+		Type:           p.Type,
+		Degree:         p.Degree,
+		Start:          p.Start,
+		RequiredMonths: p.RequiredMonths,
+		RequiredEffort: p.RequiredEffort,
+		Text:           p.Text,
+		ExpiresOn:      p.ExpiresOn,
+		Language:       p.Language,
+		Translation:    translation,
+	}
+}
+
+type apiPostingList struct {
+	// Number of postings matching the filter on all pages
+	Count    int          `json:"count"`
+	Postings []apiPosting `json:"postings"`
+
+	// Links to the previous and next page, if any
+	Prev string `json:"prev,omitempty"`
+	Next string `json:"next,omitempty"`
+}
+
+type apiFacet struct {
+	Name   string          `json:"name"`
+	Label  string          `json:"label"`
+	Values []apiFacetValue `json:"values"`
+}
+
+type apiFacetValue struct {
+	Value    string `json:"value"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiCORS allows requests to the API from the origins configured in
+// `api_cors_origins` and answers CORS preflight requests
+func apiCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			if slices.Contains(config.APICORSOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else if slices.Contains(config.APICORSOrigins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding JSON response: %v\n", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+func handlerAPIPostings(w http.ResponseWriter, r *http.Request) {
+	filter := parsePostingFilter(r.URL.Query())
+	filter.Full = true
+
+	cursor, err := parsePageCursor(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := config.PageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > apiMaxLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be a number between 1 and 100")
+			return
+		}
+	}
+
+	page, err := listPostings(filter, cursor, limit)
+	if err != nil {
+		log.Printf("error reading postings from database: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	count, err := countPostings(filter)
+	if err != nil {
+		log.Printf("error counting postings: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	list := apiPostingList{
+		Count:    count,
+		Postings: []apiPosting{},
+	}
+
+	for _, p := range page.Postings {
+		list.Postings = append(list.Postings, newAPIPosting(p))
+	}
+
+	pageURL := func(c *pageCursor) string {
+		v := filter.values()
+		if limit != config.PageSize {
+			v.Set("limit", strconv.Itoa(limit))
+		}
+		c.set(v)
+		return config.URL + "/api/v1/postings?" + v.Encode()
+	}
+
+	if page.Prev != nil {
+		list.Prev = pageURL(page.Prev)
+	}
+	if page.Next != nil {
+		list.Next = pageURL(page.Next)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handlerAPIPosting(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	p, err := getPublishedPosting(uuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "posting not found")
+		return
+	} else if err != nil {
+		log.Printf("error sql with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIPosting(p))
+}
+
+func handlerAPIFacets(w http.ResponseWriter, r *http.Request) {
+	facets, err := listFacets(parsePostingFilter(r.URL.Query()))
+	if err != nil {
+		log.Printf("error reading facets from database: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	result := []apiFacet{}
+	for _, f := range facets {
+		facet := apiFacet{Name: f.Name, Label: f.Label, Values: []apiFacetValue{}}
+		for _, v := range f.Values {
+			facet.Values = append(facet.Values, apiFacetValue{Value: v.Value, Count: v.Count, Selected: v.Selected})
+		}
+		result = append(result, facet)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func handlerAPIInstitutes(w http.ResponseWriter, r *http.Request) {
+	institutes, err := listInstitutes()
+	if err != nil {
+		log.Printf("error reading institutes from database: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if institutes == nil {
+		institutes = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, institutes)
+}
+
+func handlerAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := assets.ReadFile("assets/openapi.json")
+	if err != nil {
+		log.Printf("error reading OpenAPI document: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+// apiPostingInput is a posting as sent to the write API
+type apiPostingInput struct {
+	Email          string `json:"email"`
+	Title          string `json:"title"`
+	Institute      string `json:"institute"`
+	Advisor        string `json:"advisor"`
+	Supervisor     string `json:"supervisor"`
+	Audience       string `json:"audience"`
+	Category       string `json:"category"`
+	Type           string `json:"type"`
+	Degree         string `json:"degree"`
+	Start          string `json:"start"`
+	RequiredMonths int    `json:"required_months"`
+	RequiredEffort string `json:"required_effort"`
+	Text           string `json:"text"`
+	Language       string `json:"language"`
+
+	// Without expiry date, an update keeps the one stored before; an
+	// empty string removes it
+	ExpiresOn *string `json:"expires_on"`
+
+	// Without a translation, a translation stored before is removed
+	Translation *apiTranslation `json:"translation"`
+}
+
+func (in apiPostingInput) posting() Posting {
+	var expiresOn string
+	if in.ExpiresOn != nil {
+		expiresOn = *in.ExpiresOn
+	}
+
+	var translation PostingTranslation
+	if in.Translation != nil {
+		translation = PostingTranslation{
+			Language: in.Translation.Language,
+			Title:    in.Translation.Title,
+			Text:     in.Translation.Text,
+		}
+	}
+
+	return Posting{
+		Email:          in.Email,
+		Title:          in.Title,
+		Institute:      in.Institute,
+		Advisor:        in.Advisor,
+		Supervisor:     in.Supervisor,
+		Audience:       in.Audience,
+		Category:       in.Category,
+		Type:           in.Type,
+		Degree:         in.Degree,
+		Start:          in.Start,
+		RequiredMonths: in.RequiredMonths,
+		RequiredEffort: in.RequiredEffort,
+		Text:           in.Text,
+		ExpiresOn:      expiresOn,
+		Language:       in.Language,
+		Translation:    translation,
+	}
+}
+
+// apiManagedPosting is a posting as returned by the write API, including
+// the author's address and its moderation state
+type apiManagedPosting struct {
+	apiPosting
+
+	Email string `json:"email"`
+
+	// One of "unverified", "rejected", "published", "expired"
+	State        string `json:"state"`
+	RejectReason string `json:"reject_reason,omitempty"`
+}
+
+type apiValidationError struct {
+	Error  string            `json:"error"`
+	Fields []validationError `json:"fields"`
+}
+
+// readAPIPostingInput decodes the posting in the request body; on error
+// the response is written and false returned
+func readAPIPostingInput(w http.ResponseWriter, r *http.Request) (apiPostingInput, bool) {
+	var in apiPostingInput
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return in, false
+	}
+
+	return in, true
+}
+
+// getAPIKeyPosting returns the posting created with the API key which is
+// not deleted, along with its tokens, or `sql.ErrNoRows`
+func getAPIKeyPosting(uuid string, keyID int64) (Posting, string, string, error) {
+	var (
+		p           Posting
+		adminToken  string
+		verifyToken string
+	)
+
+	row := db.QueryRow(`
+SELECT
+    uuid,
+    created_at,
+    last_updated_at,
+    email,
+    title,
+    institute,
+    advisor,
+    supervisor,
+    audience,
+    category,
+    type,
+    degree,
+    start,
+    required_months,
+    required_effort,
+    text,
+    coalesce(expires_on, ''),
+    coalesce(expires_on < date('now'), 0),
+    verified,
+    rejected,
+    coalesce(reject_reason, ''),
+    language,
+    translation_language,
+    translation_title,
+    translation_text,
+    admin_token,
+    verify_token
+FROM postings
+WHERE uuid = ?
+    AND api_key_id = ?
+    AND deleted = 0`,
+		uuid, keyID)
+
+	err := row.Scan(&p.UUID, &p.CreatedAt, &p.LastUpdatedAt, &p.Email, &p.Title, &p.Institute, &p.Advisor,
+		&p.Supervisor, &p.Audience, &p.Category, &p.Type, &p.Degree, &p.Start, &p.RequiredMonths,
+		&p.RequiredEffort, &p.Text, &p.ExpiresOn, &p.Expired, &p.Verified, &p.Rejected, &p.RejectReason,
+		&p.Language, &p.Translation.Language, &p.Translation.Title, &p.Translation.Text, &adminToken, &verifyToken)
+
+	return p, adminToken, verifyToken, err
+}
+
+// writeAPIKeyPosting responds with the current version of a posting
+// created with the API key of the request
+func writeAPIKeyPosting(w http.ResponseWriter, r *http.Request, status int, uuid string) {
+	p, _, _, err := getAPIKeyPosting(uuid, requestAPIKey(r).ID)
+	if err != nil {
+		log.Printf("error sql with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	writeJSON(w, status, apiManagedPosting{
+		apiPosting:   newAPIPosting(p),
+		Email:        p.Email,
+		State:        p.state(),
+		RejectReason: p.RejectReason,
+	})
+}
+
+func handlerAPICreatePosting(w http.ResponseWriter, r *http.Request) {
+	key := requestAPIKey(r)
+
+	in, ok := readAPIPostingInput(w, r)
+	if !ok {
+		return
+	}
+
+	p := in.posting()
+	p.UUID = uuid.New().String()
+
+	// Postings are in the language of the client unless given
+	lang := requestLang(r)
+	if p.Language == "" {
+		p.Language = lang
+	}
+
+	if isForbiddenMailAddress(forbiddenMailRegexp, p.Email) {
+		log.Printf("attempt to create posting with forbidden mail address %q by API key %d - rejecting\n", p.Email, key.ID)
+		writeJSONError(w, http.StatusForbidden, "email address is forbidden")
+		return
+	}
+
+	if !key.EmailRegexp.MatchString(p.Email) {
+		writeJSONError(w, http.StatusForbidden, "email address is not allowed for this API key")
+		return
+	}
+
+	// For postings from email addresses that are not on the
+	// whitelist admins need to do the verification
+	var (
+		requireAdminVerification = false
+		errs                     []validationError
+	)
+
+	if err := validateMailAddress(validMailRegexp, p.Email); err != nil {
+		if errors.Is(err, ErrUnknownEmail) {
+			requireAdminVerification = true
+		} else {
+			errs = append(errs, validationError{"email", tr(lang, "Ungültige E-Mail Adresse (%q)", err.Error())})
+		}
+	}
+
+	errs = append(errs, validatePosting(lang, &p, false)...)
+
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, apiValidationError{Error: "validation failed", Fields: errs})
+		return
+	}
+
+	adminToken, err := generateToken(30)
+	if err != nil {
+		log.Printf("error generating admin token: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	verifyToken, err := generateToken(30)
+	if err != nil {
+		log.Printf("error generating verify token: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	// The API key stands in for the author's verification, postings
+	// from whitelisted addresses are published right away
+	p.Verified = !requireAdminVerification
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("error starting transaction: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := insertPosting(tx, &p, adminToken, verifyToken, key.ID); err != nil {
+		log.Printf("error inserting posting: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if requireAdminVerification {
+		if err := queueMail(tx, []string{config.AdminEmail}, defaultLanguage, "mail-admin.tmpl", newMailData(p.Email, p.UUID, p.Title, adminToken, verifyToken)); err != nil {
+			log.Printf("error queueing email: %v\n", err)
+			writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("error inserting posting: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	wakeOutbox()
+
+	log.Printf("created posting %q with API key %d\n", p.UUID, key.ID)
+
+	w.Header().Set("Location", config.URL+"/api/v1/postings/"+p.UUID)
+	writeAPIKeyPosting(w, r, http.StatusCreated, p.UUID)
+}
+
+func handlerAPIUpdatePosting(w http.ResponseWriter, r *http.Request) {
+	key := requestAPIKey(r)
+	uuid := mux.Vars(r)["uuid"]
+
+	current, adminToken, verifyToken, err := getAPIKeyPosting(uuid, key.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "posting not found")
+		return
+	} else if err != nil {
+		log.Printf("error sql with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	in, ok := readAPIPostingInput(w, r)
+	if !ok {
+		return
+	}
+
+	var errs []validationError
+
+	lang := requestLang(r)
+
+	if in.Email != "" && in.Email != current.Email {
+		errs = append(errs, validationError{"email", tr(lang, "Die E-Mail Adresse kann nicht geändert werden.")})
+	}
+
+	p := in.posting()
+	p.UUID = current.UUID
+	p.Email = current.Email
+	if p.Language == "" {
+		p.Language = current.Language
+	}
+	if in.ExpiresOn == nil {
+		p.ExpiresOn = current.ExpiresOn
+	}
+
+	// A closed posting can be updated with its expiry date in the past
+	errs = append(errs, validatePosting(lang, &p, true)...)
+
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, apiValidationError{Error: "validation failed", Fields: errs})
+		return
+	}
+
+	if err := updatePosting(db, &p); err != nil {
+		log.Printf("error updating posting with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if current.Rejected {
+		// Like in the form, an updated rejected posting needs to be
+		// verified by the admins again
+		if err := resubmitPosting(uuid, p.Email, p.Title, adminToken, verifyToken); err != nil {
+			log.Printf("error resubmitting posting with uuid %q: %v\n", uuid, err)
+			writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+	}
+
+	writeAPIKeyPosting(w, r, http.StatusOK, uuid)
+}
+
+// handlerAPIClosePosting hides a posting by letting it expire yesterday,
+// e.g. after the topic was assigned; updates without expiry date keep it
+// closed, it is opened again by updating it with a future or no (empty)
+// expiry date
+func handlerAPIClosePosting(w http.ResponseWriter, r *http.Request) {
+	key := requestAPIKey(r)
+	uuid := mux.Vars(r)["uuid"]
+
+	result, err := db.Exec(`
+UPDATE postings
+SET
+    expires_on = CASE WHEN expires_on < date('now') THEN expires_on ELSE date('now', '-1 day') END,
+    last_updated_at = CURRENT_TIMESTAMP
+WHERE uuid = ?
+    AND api_key_id = ?
+    AND deleted = 0`,
+		uuid, key.ID)
+	if err != nil {
+		log.Printf("error closing posting with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		writeJSONError(w, http.StatusNotFound, "posting not found")
+		return
+	}
+
+	writeAPIKeyPosting(w, r, http.StatusOK, uuid)
+}
+
+func handlerAPIDeletePosting(w http.ResponseWriter, r *http.Request) {
+	key := requestAPIKey(r)
+	uuid := mux.Vars(r)["uuid"]
+
+	result, err := db.Exec(`
+UPDATE postings
+SET
+    deleted = 1,
+    deleted_at = CURRENT_TIMESTAMP
+WHERE uuid = ?
+    AND api_key_id = ?
+    AND deleted = 0`,
+		uuid, key.ID)
+	if err != nil {
+		log.Printf("error soft deleting posting with uuid %q: %v\n", uuid, err)
+		writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		writeJSONError(w, http.StatusNotFound, "posting not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
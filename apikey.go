@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// apiKey is an API key allowed to manage postings through the write API;
+// it can only create postings for email addresses matching EmailRegexp
+// and only manage postings created with it
+type apiKey struct {
+	ID          int64
+	Name        string
+	EmailRegexp *regexp.Regexp
+}
+
+type apiKeyContextKey struct{}
+
+// hashAPIKey returns the hash of an API key as stored in the database;
+// the keys themselves are only shown once on creation
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// compileEmailRegexp compiles the email regexp of an API key, which must
+// match the whole address; otherwise "@example\.com" would also allow
+// "a@example.com.org"
+func compileEmailRegexp(s string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + s + `)$`)
+}
+
+// createAPIKey stores a new API key and returns it
+func createAPIKey(name, emailRegexp string) (string, error) {
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+
+	if emailRegexp == "" {
+		return "", errors.New("email regexp must not be empty")
+	}
+
+	if _, err := compileEmailRegexp(emailRegexp); err != nil {
+		return "", fmt.Errorf("invalid email regexp %q: %w", emailRegexp, err)
+	}
+
+	key, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := db.Exec("INSERT INTO api_keys (name, key_hash, email_regexp) VALUES (?, ?, ?)",
+		name, hashAPIKey(key), emailRegexp); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// printAPIKeys writes a table of all API keys to stdout
+func printAPIKeys() error {
+	rows, err := db.Query(`
+SELECT
+    k.id,
+    k.name,
+    k.email_regexp,
+    k.created_at,
+    coalesce(k.last_used_at, ''),
+    k.revoked,
+    (SELECT COUNT(*) FROM postings p WHERE p.api_key_id = k.id AND p.deleted = 0)
+FROM api_keys k
+ORDER BY k.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tNAME\tEMAIL REGEXP\tCREATED\tLAST USED\tREVOKED\tPOSTINGS\n")
+
+	for rows.Next() {
+		var (
+			id, postings        int64
+			name, emailRegexp   string
+			createdAt, lastUsed string
+			revoked             bool
+		)
+		if err := rows.Scan(&id, &name, &emailRegexp, &createdAt, &lastUsed, &revoked, &postings); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%t\t%d\n", id, name, emailRegexp, createdAt, lastUsed, revoked, postings)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return tw.Flush()
+}
+
+// revokeAPIKey disables an API key; postings created with it are kept
+func revokeAPIKey(id int64) error {
+	result, err := db.Exec("UPDATE api_keys SET revoked = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no API key with id %d", id)
+	}
+
+	return nil
+}
+
+// apiKeyOnly wraps handlers of the write API; requests need a valid API
+// key in the header `Authorization: Bearer <key>`
+func apiKeyOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing API key")
+			return
+		}
+
+		var (
+			k           apiKey
+			emailRegexp string
+		)
+
+		row := db.QueryRow("SELECT id, name, email_regexp FROM api_keys WHERE key_hash = ? AND revoked = 0", hashAPIKey(key))
+
+		if err := row.Scan(&k.ID, &k.Name, &emailRegexp); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Printf("got invalid API key for request %q\n", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+				writeJSONError(w, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+			log.Printf("error reading API key: %v\n", err)
+			writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		var err error
+		k.EmailRegexp, err = compileEmailRegexp(emailRegexp)
+		if err != nil {
+			log.Printf("got invalid email regexp %q for API key %d: %v\n", emailRegexp, k.ID, err)
+			writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		if _, err := db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", k.ID); err != nil {
+			log.Printf("error updating last use of API key %d: %v\n", k.ID, err)
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+	}
+}
+
+// requestAPIKey returns the API key of a request passed by `apiKeyOnly`
+func requestAPIKey(r *http.Request) apiKey {
+	k, _ := r.Context().Value(apiKeyContextKey{}).(apiKey)
+	return k
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestCompileEmailRegexp(t *testing.T) {
+	tests := []struct {
+		re    string
+		email string
+		want  bool
+	}{
+		{`.+@example\.com`, "a@example.com", true},
+		{`.+@example\.com`, "a@example.com.org", false},
+		{`.+@example\.com`, "a@example.com\n", false},
+		{`@example\.com`, "a@example.com", false},
+		{`.+@example\.com|.+@example\.org`, "a@example.org", true},
+		{`.+@example\.com|.+@example\.org`, "a@example.org.net", false},
+		{`.+@example\.com|.+@example\.org`, "a@example.com.net", false},
+		{`.+@(sub\.)?example\.com`, "a@sub.example.com", true},
+	}
+
+	for _, tt := range tests {
+		r, err := compileEmailRegexp(tt.re)
+		if err != nil {
+			t.Fatalf("compileEmailRegexp(%q): %v", tt.re, err)
+		}
+		if got := r.MatchString(tt.email); got != tt.want {
+			t.Errorf("compileEmailRegexp(%q).MatchString(%q) = %t, want %t", tt.re, tt.email, got, tt.want)
+		}
+	}
+
+	if _, err := compileEmailRegexp(`(`); err == nil {
+		t.Errorf("compileEmailRegexp(%q) succeeded, want error", `(`)
+	}
+}
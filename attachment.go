@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Attachment is a file attached to a posting, e.g. a project exposé
+type Attachment struct {
+	UUID        string
+	Filename    string
+	ContentType string
+	Size        int64
+
+	// The download link; for postings which are not public it carries
+	// the admin token like the preview
+	URL string
+}
+
+// attachmentUpload is an uploaded file which passed the checks of
+// `readAttachments`
+type attachmentUpload struct {
+	Attachment
+
+	Data []byte
+}
+
+// Files in `attachment_dir` without attachment are only removed after
+// this time, as they may belong to a posting being saved
+const attachmentSweepGrace = time.Hour
+
+// AttachmentLimits are the limits for attachments shown in the posting
+// form; no attachments can be uploaded if MaxAttachments is 0
+type AttachmentLimits struct {
+	MaxAttachments    int
+	MaxAttachmentSize int64
+
+	// The allowed content types for the `accept` attribute and their
+	// short names, e.g. "PDF, PNG"
+	AttachmentAccept    string
+	AttachmentTypeNames string
+}
+
+func attachmentLimits() AttachmentLimits {
+	var names []string
+	for _, t := range config.AttachmentTypes {
+		_, subtype, _ := strings.Cut(t, "/")
+		names = append(names, strings.ToUpper(subtype))
+	}
+
+	return AttachmentLimits{
+		MaxAttachments:      config.AttachmentMaxCount,
+		MaxAttachmentSize:   config.AttachmentMaxSize,
+		AttachmentAccept:    strings.Join(config.AttachmentTypes, ","),
+		AttachmentTypeNames: strings.Join(names, ", "),
+	}
+}
+
+// parsePostingForm parses the posting form, which is sent as
+// multipart/form-data for the attachments; the request may not be larger
+// than the attachments allowed plus the other fields
+func parsePostingForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.AttachmentMaxCount)*config.AttachmentMaxSize+1<<20)
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+
+	return nil
+}
+
+// readAttachments returns the files uploaded with the posting form;
+// keep is the number of attachments the posting already has
+func readAttachments(lang string, r *http.Request, keep int) ([]attachmentUpload, []validationError, error) {
+	if r.MultipartForm == nil {
+		return nil, nil, nil
+	}
+
+	var (
+		uploads []attachmentUpload
+		errs    []validationError
+	)
+
+	for _, fh := range r.MultipartForm.File["attachments"] {
+		// Browsers send an empty part if no file is selected
+		if fh.Filename == "" && fh.Size == 0 {
+			continue
+		}
+
+		filename := attachmentFilename(fh.Filename)
+
+		if fh.Size == 0 {
+			errs = append(errs, validationError{"attachments", tr(lang, "Der Anhang %q ist leer.", filename)})
+			continue
+		}
+
+		if fh.Size > config.AttachmentMaxSize {
+			errs = append(errs, validationError{"attachments", tr(lang, "Der Anhang %q ist größer als %s.", filename, formatSize(config.AttachmentMaxSize))})
+			continue
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The content type sent by the browser is only guessed from the
+		// file name
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		if !isAttachmentType(contentType) {
+			errs = append(errs, validationError{"attachments", tr(lang, "Der Anhang %q hat einen nicht erlaubten Dateityp (erlaubt: %s).", filename, attachmentLimits().AttachmentTypeNames)})
+			continue
+		}
+
+		uploads = append(uploads, attachmentUpload{
+			Attachment: Attachment{
+				UUID:        uuid.New().String(),
+				Filename:    filename,
+				ContentType: contentType,
+				Size:        int64(len(data)),
+			},
+			Data: data,
+		})
+	}
+
+	if n := keep + len(uploads); n > config.AttachmentMaxCount && len(uploads) > 0 {
+		errs = append(errs, validationError{"attachments", tr(lang, "Es sind maximal %d Anhänge erlaubt.", config.AttachmentMaxCount)})
+	}
+
+	return uploads, errs, nil
+}
+
+func isAttachmentType(contentType string) bool {
+	for _, t := range config.AttachmentTypes {
+		if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentFilename returns the name of an uploaded file without path
+// and control characters, as shown on the posting page
+func attachmentFilename(name string) string {
+	// Some browsers send the full path
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+
+	if runes := []rune(name); len(runes) > 200 {
+		name = string(runes[len(runes)-200:])
+	}
+
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+
+	return name
+}
+
+// insertAttachments stores the uploads of a posting in the database or
+// as files in `attachment_dir`, see `attachment_storage`
+func insertAttachments(ex execer, postingUUID string, uploads []attachmentUpload) error {
+	for _, a := range uploads {
+		// NULL if the content is stored as file
+		var data any
+
+		if config.AttachmentStorage == "directory" {
+			if err := os.WriteFile(attachmentPath(a.UUID), a.Data, 0o640); err != nil {
+				return err
+			}
+		} else {
+			data = a.Data
+		}
+
+		if _, err := ex.Exec(`
+INSERT INTO attachments (uuid, posting_id, filename, content_type, size, data)
+SELECT ?, id, ?, ?, ?, ?
+FROM postings
+WHERE uuid = ?`,
+			a.UUID, a.Filename, a.ContentType, a.Size, data, postingUUID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listAttachments returns the attachments of a posting without their
+// content; token is the admin token for the links to the attachments of
+// postings which are not public
+func listAttachments(postingUUID, token string) ([]Attachment, error) {
+	rows, err := db.Query(`
+SELECT a.uuid, a.filename, a.content_type, a.size
+FROM attachments a
+JOIN postings p ON p.id = a.posting_id
+WHERE p.uuid = ?
+ORDER BY a.id`,
+		postingUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.UUID, &a.Filename, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+
+		if token != "" {
+			a.URL = fmt.Sprintf("/%s/%s/attachment/%s", postingUUID, token, a.UUID)
+		} else {
+			a.URL = fmt.Sprintf("/%s/attachment/%s", postingUUID, a.UUID)
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, rows.Err()
+}
+
+// deleteAttachments removes the attachments with the given uuids from a
+// posting and returns the uuids of those stored as files, which are to be
+// removed with removeAttachmentFiles once tx is committed
+func deleteAttachments(tx *sql.Tx, postingUUID string, uuids []string) ([]string, error) {
+	var files []string
+
+	for _, id := range uuids {
+		var isFile bool
+
+		row := tx.QueryRow(`
+DELETE FROM attachments
+WHERE uuid = ?
+    AND posting_id = (SELECT id FROM postings WHERE uuid = ?)
+RETURNING data IS NULL`,
+			id, postingUUID)
+		if err := row.Scan(&isFile); errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if isFile {
+			files = append(files, id)
+		}
+	}
+
+	return files, nil
+}
+
+// removeAttachmentFiles removes the files of deleted attachments; files
+// which are left over are removed by the janitor, see sweepAttachmentDir
+func removeAttachmentFiles(ids []string) error {
+	for _, id := range ids {
+		if err := os.Remove(attachmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func attachmentPath(id string) string {
+	return filepath.Join(config.AttachmentDir, id)
+}
+
+// sweepAttachmentDir removes the files in `attachment_dir` which belong
+// to no attachment, i.e. of purged postings or of postings which failed
+// to save, and returns their number
+func sweepAttachmentDir() (int, error) {
+	if config.AttachmentStorage != "directory" {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(config.AttachmentDir)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query("SELECT uuid FROM attachments WHERE data IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool)
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		known[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int
+
+	for _, e := range entries {
+		// Only touch the files written by `insertAttachments`
+		if _, err := uuid.Parse(e.Name()); err != nil || !e.Type().IsRegular() || known[e.Name()] {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < attachmentSweepGrace {
+			continue
+		}
+
+		if err := os.Remove(attachmentPath(e.Name())); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// handlerAttachment sends an attachment of a public posting or, with
+// the admin token, of a posting which is not public
+func handlerAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	uuid := vars["uuid"]
+	token := vars["token"]
+	id := vars["id"]
+
+	var (
+		a          Attachment
+		data       []byte
+		createdAt  time.Time
+		adminToken string
+		verified   bool
+		expired    bool
+	)
+
+	row := db.QueryRow(`
+SELECT
+    a.filename,
+    a.content_type,
+    a.data,
+    a.created_at,
+    p.admin_token,
+    p.verified,
+    coalesce(p.expires_on < date('now'), 0)
+FROM attachments a
+JOIN postings p ON p.id = a.posting_id
+WHERE a.uuid = ?
+    AND p.uuid = ?
+    AND p.deleted = 0`,
+		id, uuid)
+
+	if err := row.Scan(&a.Filename, &a.ContentType, &data, &createdAt, &adminToken, &verified, &expired); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handler404(w, r)
+			return
+		} else {
+			log.Printf("error sql with attachment %q of uuid %q: %v\n", id, uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !verified || expired {
+		if token != adminToken {
+			log.Printf("got invalid admin token %q for attachment of uuid %q\n", token, uuid)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(attachmentPath(id)); err != nil {
+			log.Printf("error reading attachment %q: %v\n", id, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Only sniffed types from `attachment_types` are stored, browsers
+	// must not guess another one
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Filename}))
+
+	http.ServeContent(w, r, a.Filename, createdAt, bytes.NewReader(data))
+}
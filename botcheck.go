@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The form for new postings carries a signed token with the time it was
+// shown; it is valid for formTokenMaxAge and only once
+const formTokenMaxAge = 24 * time.Hour
+
+var (
+	// The key to sign form tokens, the cookie secret
+	formTokenKey []byte
+
+	// Used form tokens with the time they were used, kept until they
+	// expired anyway
+	usedFormTokensMu sync.Mutex
+	usedFormTokens   = make(map[string]time.Time)
+)
+
+// BotCheck are the hidden fields of the form for new postings against
+// automated submissions; empty in the edit form, which requires the
+// admin token anyway
+type BotCheck struct {
+	FormToken string
+
+	// The number of leading zero bits of the proof of work, 0 if none is
+	// required, see `form_pow_difficulty`
+	PowDifficulty int
+}
+
+func newBotCheck() (BotCheck, error) {
+	random, err := generateToken(16)
+	if err != nil {
+		return BotCheck{}, err
+	}
+
+	payload := fmt.Sprintf("%d.%s", time.Now().Unix(), random)
+
+	return BotCheck{
+		FormToken:     payload + "." + signFormToken(payload),
+		PowDifficulty: config.FormPowDifficulty,
+	}, nil
+}
+
+func signFormToken(payload string) string {
+	mac := hmac.New(sha256.New, formTokenKey)
+	mac.Write([]byte("form-token." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isHoneypotFilled reports whether the field hidden from humans was
+// filled, which bots filling every field do
+func isHoneypotFilled(r *http.Request) bool {
+	return r.FormValue("website") != ""
+}
+
+// checkBotCheck returns the errors for a form token which is invalid,
+// expired, already used or sent faster than `form_min_seconds` after the
+// form was shown, and for a missing proof of work; a valid token is used
+// up, the form shown again gets a new one
+func checkBotCheck(lang string, r *http.Request) []string {
+	token := r.FormValue("form-token")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(signFormToken(parts[0]+"."+parts[1]))) {
+		return []string{tr(lang, "Das Formular ist abgelaufen. Bitte senden Sie es erneut.")}
+	}
+
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return []string{tr(lang, "Das Formular ist abgelaufen. Bitte senden Sie es erneut.")}
+	}
+
+	age := time.Since(time.Unix(issued, 0))
+
+	if age > formTokenMaxAge {
+		return []string{tr(lang, "Das Formular ist abgelaufen. Bitte senden Sie es erneut.")}
+	}
+
+	if age < time.Duration(config.FormMinSeconds)*time.Second {
+		return []string{tr(lang, "Das Formular wurde zu schnell gesendet. Bitte prüfen Sie Ihre Angaben und senden Sie es erneut.")}
+	}
+
+	if config.FormPowDifficulty > 0 && !checkProofOfWork(token, r.FormValue("pow-nonce"), config.FormPowDifficulty) {
+		return []string{tr(lang, "Die Prüfung gegen automatisierte Anfragen ist fehlgeschlagen. Bitte aktivieren Sie JavaScript und senden Sie das Formular erneut.")}
+	}
+
+	if !useFormToken(token) {
+		return []string{tr(lang, "Das Formular ist abgelaufen. Bitte senden Sie es erneut.")}
+	}
+
+	return nil
+}
+
+// checkProofOfWork reports whether the SHA-256 hash of token, ":" and
+// nonce starts with difficulty zero bits; the form finds the nonce with
+// JavaScript before it is sent
+func checkProofOfWork(token, nonce string, difficulty int) bool {
+	if nonce == "" || len(nonce) > 32 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return zeros >= difficulty
+}
+
+// useFormToken marks a form token as used and reports whether it was
+// unused, a solved form must not be sent again
+func useFormToken(token string) bool {
+	usedFormTokensMu.Lock()
+	defer usedFormTokensMu.Unlock()
+
+	if _, used := usedFormTokens[token]; used {
+		return false
+	}
+
+	usedFormTokens[token] = time.Now()
+	return true
+}
+
+// pruneFormTokens drops the used form tokens which expired anyway, run
+// by the janitor
+func pruneFormTokens() {
+	usedFormTokensMu.Lock()
+	defer usedFormTokensMu.Unlock()
+
+	for token, usedAt := range usedFormTokens {
+		if time.Since(usedAt) > formTokenMaxAge {
+			delete(usedFormTokens, token)
+		}
+	}
+}
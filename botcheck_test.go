@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solveProofOfWork finds a nonce like the JavaScript of the form does
+func solveProofOfWork(token string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(token + ":" + nonce))
+
+		zeros := 0
+		for _, b := range sum {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	token := "1700000000.abc.def"
+
+	for _, difficulty := range []int{1, 4, 8, 12} {
+		nonce := solveProofOfWork(token, difficulty)
+
+		if !checkProofOfWork(token, nonce, difficulty) {
+			t.Errorf("checkProofOfWork(%q, %q, %d) = false, want true", token, nonce, difficulty)
+		}
+		if checkProofOfWork(token+"x", nonce, 24) {
+			t.Errorf("checkProofOfWork(%q, %q, 24) = true, want false", token+"x", nonce)
+		}
+	}
+
+	tests := []struct {
+		nonce      string
+		difficulty int
+		want       bool
+	}{
+		{"", 0, false},
+		{strings.Repeat("1", 33), 0, false},
+		{strings.Repeat("1", 32), 0, true},
+		{"1", 0, true},
+		{"1", 257, false},
+	}
+
+	for _, tt := range tests {
+		if got := checkProofOfWork(token, tt.nonce, tt.difficulty); got != tt.want {
+			t.Errorf("checkProofOfWork(%q, %q, %d) = %v, want %v", token, tt.nonce, tt.difficulty, got, tt.want)
+		}
+	}
+}
+
+// formToken returns a signed form token issued at the given time
+func formToken(issued time.Time) string {
+	payload := fmt.Sprintf("%d.%s", issued.Unix(), "random")
+	return payload + "." + signFormToken(payload)
+}
+
+func TestCheckBotCheck(t *testing.T) {
+	formTokenKey = []byte("test key")
+	config.FormMinSeconds = 5
+	config.FormPowDifficulty = 0
+
+	valid := formToken(time.Now().Add(-time.Minute))
+	powToken := formToken(time.Now().Add(-2 * time.Minute))
+
+	tests := []struct {
+		name       string
+		token      string
+		nonce      string
+		difficulty int
+		want       string
+	}{
+		{"missing token", "", "", 0, "abgelaufen"},
+		{"malformed token", "a.b", "", 0, "abgelaufen"},
+		{"wrong signature", valid[:len(valid)-4] + "0000", "", 0, "abgelaufen"},
+		{"other key", "1700000000.random." + strings.Repeat("0", 64), "", 0, "abgelaufen"},
+		{"invalid time", "x.random." + signFormToken("x.random"), "", 0, "abgelaufen"},
+		{"expired", formToken(time.Now().Add(-formTokenMaxAge - time.Minute)), "", 0, "abgelaufen"},
+		{"too fast", formToken(time.Now()), "", 0, "zu schnell"},
+		{"missing proof of work", formToken(time.Now().Add(-3 * time.Minute)), "", 8, "automatisierte Anfragen"},
+		{"valid", valid, "", 0, ""},
+		{"used", valid, "", 0, "abgelaufen"},
+		{"valid proof of work", powToken, solveProofOfWork(powToken, 8), 8, ""},
+	}
+
+	for _, tt := range tests {
+		config.FormPowDifficulty = tt.difficulty
+
+		form := url.Values{"form-token": {tt.token}, "pow-nonce": {tt.nonce}}
+		r := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		errs := checkBotCheck(defaultLanguage, r)
+
+		switch {
+		case tt.want == "" && len(errs) > 0:
+			t.Errorf("%s: checkBotCheck = %q, want no errors", tt.name, errs)
+		case tt.want != "" && (len(errs) != 1 || !strings.Contains(errs[0], tt.want)):
+			t.Errorf("%s: checkBotCheck = %q, want an error containing %q", tt.name, errs, tt.want)
+		}
+	}
+
+	config.FormPowDifficulty = 0
+}
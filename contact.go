@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The link to confirm a contact message is valid for contactConfirmHours;
+// the messages are kept for the rate limit and removed by the janitor
+// after contactKeepDays
+const (
+	contactConfirmHours = 24
+	contactKeepDays     = 7
+)
+
+// contactMessage is a message to the author of a posting, sent with the
+// contact form on the posting page; it is relayed once the sender
+// confirmed their address, the author's address is never shown
+type contactMessage struct {
+	Name    string
+	Email   string
+	Message string
+}
+
+func readContactMessage(r *http.Request) contactMessage {
+	return contactMessage{
+		Name:    strings.TrimSpace(r.FormValue("contact-name")),
+		Email:   strings.TrimSpace(r.FormValue("contact-email")),
+		Message: strings.TrimSpace(r.FormValue("contact-message")),
+	}
+}
+
+func validateContactMessage(lang string, m contactMessage) []string {
+	var errs []string
+
+	if m.Name == "" {
+		errs = append(errs, tr(lang, "Bitte geben Sie Ihren Namen an."))
+	} else if len(m.Name) > 200 {
+		errs = append(errs, tr(lang, "Der Name darf maximal 200 Zeichen lang sein."))
+	} else if hasControlChars(m.Name) {
+		errs = append(errs, tr(lang, "Der Name darf keine Zeilenumbrüche oder Steuerzeichen enthalten."))
+	}
+
+	if addr, err := mail.ParseAddress(m.Email); err != nil || addr.Address != m.Email {
+		errs = append(errs, tr(lang, "Ungültige E-Mail Adresse (%q)", m.Email))
+	}
+
+	if m.Message == "" {
+		errs = append(errs, tr(lang, "Eine Nachricht ist erforderlich."))
+	} else if len(m.Message) > 5000 {
+		errs = append(errs, tr(lang, "Die Nachricht darf maximal 5000 Zeichen lang sein."))
+	}
+
+	return errs
+}
+
+// countContactMessages returns the number of contact messages sent from
+// email within the last 24 hours, confirmed or not
+func countContactMessages(email string) (int, error) {
+	var n int
+
+	err := db.QueryRow(`
+SELECT COUNT(*)
+FROM contact_messages
+WHERE email = ?
+    AND created_at > datetime('now', '-1 day')`,
+		strings.ToLower(email)).Scan(&n)
+
+	return n, err
+}
+
+// submitContactMessage stores a message to the author of the posting and
+// mails the sender the link to confirm their address
+func submitContactMessage(postingUUID, title, lang string, m contactMessage) error {
+	token, err := generateToken(30)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+INSERT INTO contact_messages (posting_id, confirm_token, language, name, email, message)
+SELECT id, ?, ?, ?, ?, ?
+FROM postings
+WHERE uuid = ?`,
+		token, lang, m.Name, strings.ToLower(m.Email), m.Message, postingUUID); err != nil {
+		return err
+	}
+
+	if err := queueMail(tx, []string{m.Email}, lang, "mail-contact-confirm.tmpl", struct {
+		Name        string
+		Title       string
+		ConfirmLink string
+		ValidHours  int
+	}{
+		Name:        m.Name,
+		Title:       title,
+		ConfirmLink: fmt.Sprintf("%s/contact/%s", config.URL, token),
+		ValidHours:  contactConfirmHours,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
+}
+
+// handlerContactConfirm relays a contact message to the author of the
+// posting once the sender followed the link in the confirmation mail
+func handlerContactConfirm(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
+	if err != nil {
+		log.Printf("error retrieving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	token := mux.Vars(r)["token"]
+
+	lang := requestLang(r)
+
+	var (
+		id          int64
+		m           contactMessage
+		confirmed   bool
+		linkExpired bool
+		public      bool
+		postingUUID string
+		title       string
+		authorEmail string
+		authorLang  string
+	)
+
+	row := db.QueryRow(`
+SELECT
+    m.id,
+    m.name,
+    m.email,
+    m.message,
+    m.confirmed_at IS NOT NULL,
+    m.created_at < datetime('now', ?),
+    p.verified = 1 AND p.deleted = 0 AND coalesce(p.expires_on >= date('now'), 1),
+    p.uuid,
+    p.title,
+    p.email,
+    p.language
+FROM contact_messages m
+JOIN postings p ON p.id = m.posting_id
+WHERE m.confirm_token = ?`,
+		fmt.Sprintf("-%d hours", contactConfirmHours), token)
+
+	if err := row.Scan(&id, &m.Name, &m.Email, &m.Message, &confirmed, &linkExpired, &public,
+		&postingUUID, &title, &authorEmail, &authorLang); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handler404(w, r)
+			return
+		} else {
+			log.Printf("error sql with contact token %q: %v\n", token, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	redirect := fmt.Sprintf("%s/%s", config.URL, postingUUID)
+
+	switch {
+	case confirmed:
+		session.AddFlash(tr(lang, "Ihre Nachricht wurde bereits weitergeleitet."))
+	case !public:
+		session.AddFlash(tr(lang, "Das Angebot ist nicht mehr verfügbar, Ihre Nachricht wurde nicht weitergeleitet."))
+		redirect = config.URL
+	case linkExpired:
+		session.AddFlash(tr(lang, "Der Link ist abgelaufen. Bitte senden Sie Ihre Nachricht erneut."))
+	default:
+		if err := relayContactMessage(id, postingUUID, title, authorEmail, authorLang, m); err != nil {
+			log.Printf("error relaying contact message %d for uuid %q: %v\n", id, postingUUID, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		session.AddFlash(tr(lang, "Ihre Nachricht wurde weitergeleitet."))
+	}
+
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// relayContactMessage marks the message as confirmed and mails it to the
+// author with the sender's address as Reply-To
+func relayContactMessage(id int64, postingUUID, title, authorEmail, authorLang string, m contactMessage) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+UPDATE contact_messages
+SET confirmed_at = CURRENT_TIMESTAMP
+WHERE id = ?
+    AND confirmed_at IS NULL`,
+		id)
+	if err != nil {
+		return err
+	}
+
+	// Following the link twice at once must not send the message twice
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if err := queueMail(tx, []string{authorEmail}, authorLang, "mail-contact.tmpl", struct {
+		Name        string
+		Email       string
+		ReplyTo     string
+		Message     string
+		Title       string
+		PostingLink string
+	}{
+		Name:    m.Name,
+		Email:   m.Email,
+		ReplyTo: (&mail.Address{Name: m.Name, Address: m.Email}).String(),
+		// Indented, the message is set apart from the text of the mail
+		Message:     "   " + strings.ReplaceAll(strings.ReplaceAll(m.Message, "\r\n", "\n"), "\n", "\n   "),
+		Title:       title,
+		PostingLink: fmt.Sprintf("%s/%s", config.URL, postingUUID),
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimOptions are set if outgoing mails are to be DKIM signed
+var dkimOptions *dkim.SignOptions
+
+// The headers covered by the DKIM signature
+var dkimHeaderKeys = []string{"From", "To", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// newDKIMOptions returns the DKIM signing options from the config, nil
+// if DKIM signing is not configured
+func newDKIMOptions(c Config) (*dkim.SignOptions, error) {
+	if c.DKIMDomain == "" && c.DKIMSelector == "" && c.DKIMKeyFile == "" {
+		return nil, nil
+	}
+
+	if c.DKIMDomain == "" || c.DKIMSelector == "" || c.DKIMKeyFile == "" {
+		return nil, errors.New("dkim_domain, dkim_selector and dkim_key_file must all be set")
+	}
+
+	signer, err := readDKIMKey(c.DKIMKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM key from %q: %w", c.DKIMKeyFile, err)
+	}
+
+	return &dkim.SignOptions{
+		Domain:                 c.DKIMDomain,
+		Selector:               c.DKIMSelector,
+		Signer:                 signer,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             dkimHeaderKeys,
+	}, nil
+}
+
+// readDKIMKey reads a PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8)
+// private key
+func readDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// dkimSign returns the mail with a DKIM signature if DKIM signing is
+// configured
+func dkimSign(msg []byte) ([]byte, error) {
+	if dkimOptions == nil {
+		return msg, nil
+	}
+
+	signed := new(bytes.Buffer)
+	if err := dkim.Sign(signed, bytes.NewReader(msg), dkimOptions); err != nil {
+		return nil, fmt.Errorf("failed to DKIM sign mail: %w", err)
+	}
+
+	return signed.Bytes(), nil
+}
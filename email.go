@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
+	"os"
 	"regexp"
+	"strings"
+	"time"
 )
 
 var ErrUnknownEmail = errors.New("E-Mail ist nicht auf der Liste der zulässigen Adressen bzw. Einrichtungen")
@@ -41,3 +50,252 @@ func isForbiddenMailAddress(forbiddenRegexp []*regexp.Regexp, email string) bool
 
 	return false
 }
+
+// mailData is passed to the mail templates
+type mailData struct {
+	To          string
+	UUID        string
+	Title       string
+	PreviewLink string
+	VerifyLink  string
+	RejectLink  string
+	AdminLink   string
+}
+
+// newMailData returns the mail template data for a posting with all
+// private links set
+func newMailData(to, uuid, title, adminToken, verifyToken string) mailData {
+	return mailData{
+		To:          to,
+		UUID:        uuid,
+		Title:       title,
+		PreviewLink: fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, adminToken),
+		VerifyLink:  fmt.Sprintf("%s/%s/%s/verify", config.URL, uuid, verifyToken),
+		RejectLink:  fmt.Sprintf("%s/%s/%s/reject", config.URL, uuid, verifyToken),
+		AdminLink:   fmt.Sprintf("%s/%s/%s/admin", config.URL, uuid, adminToken),
+	}
+}
+
+// sendMail queues the mail template `name` for sending, see `queueMail`
+func sendMail(to []string, lang, name string, data any) error {
+	if err := queueMail(db, to, lang, name, data); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
+}
+
+// deliverMail dates and, if DKIM is configured, signs a mail and sends
+// it via the configured mail transport; mails are dated and signed when
+// sent rather than when queued, as retries may take hours
+func deliverMail(to []string, msg []byte) error {
+	msg = append([]byte(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z))), msg...)
+
+	msg, err := dkimSign(msg)
+	if err != nil {
+		return err
+	}
+
+	return transport.Send(config.SMTPMailFrom, to, msg)
+}
+
+// composeMail executes the plain text mail template `name` in lang, or
+// in German if it is not translated, and returns a MIME message with the
+// text and an HTML alternative, and its subject; mail templates start
+// with a `Subject` and optionally a `Reply-To` header followed by an
+// empty line and the text
+func composeMail(to []string, lang, name string, data any) ([]byte, string, error) {
+	if _, ok := tmpl[lang]; !ok {
+		lang = defaultLanguage
+	}
+
+	templateName := mailTemplateName(name, lang)
+	if mailTmpl.Lookup(templateName) == nil {
+		templateName, lang = name, defaultLanguage
+	}
+	mailTemplate := mailTmpl.Lookup(templateName)
+	if mailTemplate == nil {
+		return nil, "", fmt.Errorf("failed to find mail template %q", name)
+	}
+
+	mailText := new(bytes.Buffer)
+	if err := mailTemplate.Execute(mailText, data); err != nil {
+		return nil, "", fmt.Errorf("failed to execute mail template %q: %w", name, err)
+	}
+	text := mailText.Bytes()
+
+	header := make(map[string]string)
+	for _, key := range mailHeaders[templateName] {
+		value := new(bytes.Buffer)
+		if err := mailTmpl.ExecuteTemplate(value, templateName+"#"+key, data); err != nil {
+			return nil, "", fmt.Errorf("failed to execute header %q of mail template %q: %w", key, name, err)
+		}
+
+		// Line breaks in values, e.g. of titles, must not start
+		// another header
+		header[key] = strings.Join(strings.Fields(value.String()), " ")
+	}
+
+	subject := header["Subject"]
+
+	// Mails relayed for others are answered to them
+	var replyTo []string
+	if h := header["Reply-To"]; h != "" {
+		addrs, err := mail.ParseAddressList(h)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse Reply-To of mail template %q: %w", name, err)
+		}
+		for _, addr := range addrs {
+			replyTo = append(replyTo, addr.String())
+		}
+	}
+
+	html := new(bytes.Buffer)
+	if err := tmpl[lang].ExecuteTemplate(html, "mail-html", struct {
+		Lang       string
+		Subject    string
+		Paragraphs []mailParagraph
+	}{
+		Lang:       lang,
+		Subject:    subject,
+		Paragraphs: mailParagraphs(string(text)),
+	}); err != nil {
+		return nil, "", fmt.Errorf("failed to execute mail template %q: %w", "mail-html", err)
+	}
+
+	messageID, err := generateToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	domain := "localhost"
+	if _, d, ok := strings.Cut(config.SMTPMailFrom, "@"); ok {
+		domain = d
+	}
+
+	var recipients []string
+	for _, addr := range to {
+		recipients = append(recipients, (&mail.Address{Address: addr}).String())
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write(part.content); err != nil {
+			return nil, "", err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", (&mail.Address{Name: config.TitleText, Address: config.SMTPMailFrom}).String())
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	if len(replyTo) > 0 {
+		fmt.Fprintf(msg, "Reply-To: %s\r\n", strings.Join(replyTo, ", "))
+	}
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Message-ID: <%s@%s>\r\n", messageID, domain)
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%q\r\n", mw.Boundary())
+	fmt.Fprintf(msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), subject, nil
+}
+
+// mailParagraph is a paragraph of the HTML alternative of a mail
+type mailParagraph struct {
+	// Indented paragraphs hold the links and quotes of the text
+	Indent bool
+	Lines  []mailLine
+}
+
+type mailLine struct {
+	Text string
+	Link bool
+}
+
+// mailParagraphs splits the text of a mail into paragraphs at empty
+// lines for the HTML alternative
+func mailParagraphs(text string) []mailParagraph {
+	var (
+		paragraphs []mailParagraph
+		p          *mailParagraph
+	)
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			p = nil
+			continue
+		}
+
+		if p == nil {
+			paragraphs = append(paragraphs, mailParagraph{Indent: strings.HasPrefix(line, " ")})
+			p = &paragraphs[len(paragraphs)-1]
+		}
+
+		p.Lines = append(p.Lines, mailLine{
+			Text: trimmed,
+			Link: strings.HasPrefix(trimmed, "https://") || strings.HasPrefix(trimmed, "http://"),
+		})
+	}
+
+	return paragraphs
+}
+
+// sendTestMail sends a test mail right away, bypassing the outbox; the
+// SMTP dialogue is written to stderr
+func sendTestMail(to string) error {
+	if _, err := mail.ParseAddress(to); err != nil {
+		return err
+	}
+
+	msg, _, err := composeMail([]string{to}, defaultLanguage, "mail-test.tmpl", struct {
+		URL       string
+		Transport string
+	}{
+		URL:       config.URL,
+		Transport: config.MailTransport,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err = dkimSign(msg)
+	if err != nil {
+		return err
+	}
+
+	t := transport
+	if st, ok := t.(smtpTransport); ok {
+		st.Trace = os.Stderr
+		t = st
+	}
+
+	return t.Send(config.SMTPMailFrom, []string{to}, msg)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupMailTemplates(t *testing.T) {
+	t.Helper()
+
+	if err := loadCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(""); err != nil {
+		t.Fatal(err)
+	}
+	config.SMTPMailFrom = "boerse@example.com"
+}
+
+type contactMailData struct {
+	Title       string
+	Name        string
+	Email       string
+	Message     string
+	PostingLink string
+	ReplyTo     string
+}
+
+func TestComposeMail(t *testing.T) {
+	setupMailTemplates(t)
+
+	tests := []struct {
+		name        string
+		lang        string
+		data        contactMailData
+		wantSubject string
+		wantReplyTo string
+	}{
+		{
+			name:        "plain title",
+			lang:        "de",
+			data:        contactMailData{Title: "Robotik", ReplyTo: "<a@example.com>"},
+			wantSubject: "Forschungsarbeitbörse: Nachricht zu Ihrem Angebot „Robotik“",
+			wantReplyTo: "<a@example.com>",
+		},
+		{
+			name:        "translated",
+			lang:        "en",
+			data:        contactMailData{Title: "Robotics", ReplyTo: "<a@example.com>"},
+			wantSubject: `Forschungsarbeitbörse: Message about your posting "Robotics"`,
+			wantReplyTo: "<a@example.com>",
+		},
+		{
+			name:        "unknown language",
+			lang:        "fr",
+			data:        contactMailData{Title: "Robotik", ReplyTo: "<a@example.com>"},
+			wantSubject: "Forschungsarbeitbörse: Nachricht zu Ihrem Angebot „Robotik“",
+			wantReplyTo: "<a@example.com>",
+		},
+		{
+			name:        "CR LF in title",
+			lang:        "de",
+			data:        contactMailData{Title: "X\r\nBcc: evil@example.net\r\n\r\nText", ReplyTo: "<a@example.com>"},
+			wantSubject: "Forschungsarbeitbörse: Nachricht zu Ihrem Angebot „X Bcc: evil@example.net Text“",
+			wantReplyTo: "<a@example.com>",
+		},
+		{
+			name:        "LF in title",
+			lang:        "en",
+			data:        contactMailData{Title: "X\nBcc: evil@example.net", ReplyTo: "<a@example.com>"},
+			wantSubject: `Forschungsarbeitbörse: Message about your posting "X Bcc: evil@example.net"`,
+			wantReplyTo: "<a@example.com>",
+		},
+		{
+			name:        "CR LF in Reply-To name",
+			lang:        "de",
+			data:        contactMailData{Title: "Robotik", ReplyTo: (&mail.Address{Name: "A\r\nBcc: evil@example.net", Address: "a@example.com"}).String()},
+			wantSubject: "Forschungsarbeitbörse: Nachricht zu Ihrem Angebot „Robotik“",
+			wantReplyTo: (&mail.Address{Name: "A\r\nBcc: evil@example.net", Address: "a@example.com"}).String(),
+		},
+	}
+
+	for _, tt := range tests {
+		msg, subject, err := composeMail([]string{"author@example.org"}, tt.lang, "mail-contact.tmpl", tt.data)
+		if err != nil {
+			t.Errorf("%s: composeMail: %v", tt.name, err)
+			continue
+		}
+
+		if subject != tt.wantSubject {
+			t.Errorf("%s: subject = %q, want %q", tt.name, subject, tt.wantSubject)
+		}
+
+		m, err := mail.ReadMessage(bytes.NewReader(msg))
+		if err != nil {
+			t.Errorf("%s: mail.ReadMessage: %v", tt.name, err)
+			continue
+		}
+
+		for key := range m.Header {
+			switch key {
+			case "From", "To", "Reply-To", "Subject", "Message-Id", "Mime-Version", "Content-Type":
+			default:
+				t.Errorf("%s: unexpected header %q", tt.name, key)
+			}
+		}
+
+		if got, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); err != nil || got != tt.wantSubject {
+			t.Errorf("%s: Subject header = %q (%v), want %q", tt.name, got, err, tt.wantSubject)
+		}
+
+		if got := m.Header.Get("Reply-To"); got != tt.wantReplyTo {
+			t.Errorf("%s: Reply-To header = %q, want %q", tt.name, got, tt.wantReplyTo)
+		}
+
+		if got := m.Header.Get("To"); got != "<author@example.org>" {
+			t.Errorf("%s: To header = %q", tt.name, got)
+		}
+	}
+}
+
+func TestComposeMailWithoutReplyTo(t *testing.T) {
+	setupMailTemplates(t)
+
+	type expiryMailData struct {
+		mailData
+		ExpiresOn  string
+		ExtendLink string
+		ExtendDays int
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		data        any
+		wantSubject string
+	}{
+		{
+			name:     "test mail",
+			template: "mail-test.tmpl",
+			data: struct {
+				URL       string
+				Transport string
+			}{URL: "https://example.com", Transport: "smtp"},
+			wantSubject: "Forschungsarbeitbörse: Test-E-Mail",
+		},
+		{
+			// A title must not add the Reply-To the template lacks
+			name:        "Reply-To in title",
+			template:    "mail-user-expiry.tmpl",
+			data:        expiryMailData{mailData: mailData{Title: "X\r\nReply-To: <evil@example.net>"}},
+			wantSubject: "Forschungsarbeitbörse: Angebot „X Reply-To: <evil@example.net>“ läuft bald ab",
+		},
+	}
+
+	for _, tt := range tests {
+		msg, subject, err := composeMail([]string{"author@example.org"}, "de", tt.template, tt.data)
+		if err != nil {
+			t.Errorf("%s: composeMail: %v", tt.name, err)
+			continue
+		}
+
+		if subject != tt.wantSubject {
+			t.Errorf("%s: subject = %q, want %q", tt.name, subject, tt.wantSubject)
+		}
+
+		m, err := mail.ReadMessage(bytes.NewReader(msg))
+		if err != nil {
+			t.Errorf("%s: mail.ReadMessage: %v", tt.name, err)
+			continue
+		}
+		if _, ok := m.Header["Reply-To"]; ok {
+			t.Errorf("%s: unexpected Reply-To header %q", tt.name, m.Header.Get("Reply-To"))
+		}
+	}
+}
+
+func TestLoadMailTemplateHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"valid", "Subject: Test\n\nText\n", ""},
+		{"CR LF", "Subject: Test\r\n\r\nText\r\n", ""},
+		{"no empty line", "Subject: Test\nText\n", "missing empty line"},
+		{"no subject", "Reply-To: a@example.com\n\nText\n", "missing Subject"},
+		{"invalid header", "Subject: Test\nText\n\nText\n", "invalid header line"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "mail-test.tmpl"), []byte(tt.text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := loadTemplates(dir)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: loadTemplates: %v", tt.name, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: loadTemplates error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// Restore the embedded templates for other tests
+	if err := loadTemplates(""); err != nil {
+		t.Fatal(err)
+	}
+}
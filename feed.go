@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// Number of postings in a feed and length of the item summaries
+const (
+	feedSize          = 30
+	feedExcerptLength = 250
+)
+
+// postingFeed returns the feed of the latest postings matching the filter
+// in the request, with the whole posting as content of each item; the
+// items are in the same order as the returned postings
+func postingFeed(r *http.Request) (*feeds.Feed, []Posting, error) {
+	filter := parsePostingFilter(r.URL.Query())
+	filter.Full = true
+	filter.Lang = requestLang(r)
+
+	page, err := listPostings(filter, pageCursor{}, feedSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	title := config.TitleText
+	var selection []string
+	if filter.Query != "" {
+		selection = append(selection, fmt.Sprintf("„%s“", filter.Query))
+	}
+	for _, facet := range postingFacets {
+		if value, ok := filter.Facets[facet.Name]; ok {
+			selection = append(selection, value)
+		}
+	}
+	if len(selection) > 0 {
+		title += ": " + strings.Join(selection, ", ")
+	}
+
+	feed := &feeds.Feed{
+		Title:   title,
+		Link:    &feeds.Link{Href: config.URL + filter.URL()},
+		Created: time.Now(),
+	}
+
+	for _, p := range page.Postings {
+		var content bytes.Buffer
+		if err := tmpl[filter.Lang].ExecuteTemplate(&content, "feed-item", p); err != nil {
+			return nil, nil, err
+		}
+
+		feed.Items = append(feed.Items, &feeds.Item{
+			Title:       p.Title,
+			Id:          p.UUID,
+			Link:        &feeds.Link{Href: config.URL + "/" + p.UUID},
+			Description: template.HTMLEscapeString(excerpt(plainText(p.Text), feedExcerptLength)),
+			Content:     content.String(),
+			Created:     p.CreatedAt,
+			Updated:     p.LastUpdatedAt,
+		})
+
+		if p.LastUpdatedAt.After(feed.Updated) {
+			feed.Updated = p.LastUpdatedAt
+		}
+	}
+
+	return feed, page.Postings, nil
+}
+
+// excerpt returns the first n characters of s, marking cut text with "…"
+func excerpt(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
+
+// feedCategories returns the values of a posting which feed readers can
+// group by
+func feedCategories(p Posting) []string {
+	var categories []string
+	for _, c := range []string{p.Category, p.Type, p.Institute, p.Degree} {
+		if c != "" {
+			categories = append(categories, c)
+		}
+	}
+	return categories
+}
+
+func handlerRSSFeed(w http.ResponseWriter, r *http.Request) {
+	feed, postings, err := postingFeed(r)
+	if err != nil {
+		log.Printf("error generating feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rssFeed := (&feeds.Rss{Feed: feed}).RssFeed()
+	for i, item := range rssFeed.Items {
+		item.Category = strings.Join(feedCategories(postings[i]), ", ")
+	}
+
+	rss, err := feeds.ToXML(rssFeed)
+	if err != nil {
+		log.Printf("error generating RSS feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml")
+	w.Write([]byte(rss))
+}
+
+func handlerAtomFeed(w http.ResponseWriter, r *http.Request) {
+	feed, _, err := postingFeed(r)
+	if err != nil {
+		log.Printf("error generating feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	atomFeed := (&feeds.Atom{Feed: feed}).AtomFeed()
+	atomFeed.Id = config.URL + r.URL.RequestURI()
+	for _, entry := range atomFeed.Entries {
+		// Atom requires the ids to be URIs
+		entry.Id = "urn:uuid:" + entry.Id
+	}
+
+	atom, err := feeds.ToXML(atomFeed)
+	if err != nil {
+		log.Printf("error generating Atom feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	w.Write([]byte(atom))
+}
+
+func handlerJSONFeed(w http.ResponseWriter, r *http.Request) {
+	feed, postings, err := postingFeed(r)
+	if err != nil {
+		log.Printf("error generating feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonFeed := (&feeds.JSON{Feed: feed}).JSONFeed()
+	jsonFeed.FeedUrl = config.URL + r.URL.RequestURI()
+	for i, item := range jsonFeed.Items {
+		// Unlike in RSS and Atom, the summary is plain text
+		item.Summary = excerpt(plainText(postings[i].Text), feedExcerptLength)
+		item.Tags = feedCategories(postings[i])
+	}
+
+	json, err := jsonFeed.ToJSON()
+	if err != nil {
+		log.Printf("error generating JSON feed: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.Write([]byte(json))
+}
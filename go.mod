@@ -6,6 +6,7 @@ toolchain go1.23.5
 
 require (
 	github.com/BurntSushi/toml v1.4.0
+	github.com/emersion/go-msgauth v0.7.0
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/feeds v1.2.0
 	github.com/gorilla/mux v1.8.1
@@ -15,3 +16,5 @@ require (
 )
 
 require github.com/gorilla/securecookie v1.1.2
+
+require golang.org/x/crypto v0.31.0 // indirect
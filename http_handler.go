@@ -1,28 +1,32 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"net/smtp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/feeds"
 	"github.com/gorilla/mux"
 )
 
 type Posting struct {
-	UUID      string
-	CreatedAt time.Time
+	UUID          string
+	CreatedAt     time.Time
+	LastUpdatedAt time.Time
 
 	Email string
 
+	// The language of the author's mails, see `languages`
+	Language string
+
 	Title          string
 	Institute      string
 	Advisor        string
@@ -35,6 +39,36 @@ type Posting struct {
 	RequiredMonths int
 	RequiredEffort string
 	Text           string
+
+	// Title and text in a second language, if any
+	Translation PostingTranslation
+
+	// Files attached to the posting, only set for the posting page and
+	// the form
+	Attachments []Attachment
+
+	// Date (yyyy-mm-dd) after which the posting is hidden, if any
+	ExpiresOn string
+
+	// Moderation state
+	Verified     bool
+	Deleted      bool
+	Expired      bool
+	Rejected     bool
+	RejectReason string
+
+	// Title and text excerpt with search matches marked, only set
+	// for search results
+	TitleHighlight string
+	TextHighlight  string
+}
+
+// PostingTranslation is the title and text of a posting in another
+// language than the posting's own
+type PostingTranslation struct {
+	Language string
+	Title    string
+	Text     string
 }
 
 type TemplateDataPage struct {
@@ -56,18 +90,48 @@ type TemplateDataPage struct {
 
 	// The build version, automatically set by the build system
 	Version string
+
+	// The language of the page and the links to switch it, if the
+	// page is translated
+	Lang      string
+	Languages []languageLink
 }
 
 type TemplateDataIndex struct {
 	TemplateDataPage
 
+	// The postings on the current page and the number of postings on
+	// all pages
 	Postings []Posting
+	Count    int
+
+	// Links to the previous and next page, if any
+	PrevURL string
+	NextURL string
+
+	// The search term, selected facets and sort order
+	Filter postingFilter
+
+	// The facets with their values and counts for the current filter
+	Facets []Facet
 }
 
 type TemplateDataPosting struct {
 	TemplateDataPage
 
 	Posting
+
+	// The contact form is only shown on public postings
+	ContactForm bool
+	Contact     contactMessage
+}
+
+type TemplateDataReject struct {
+	TemplateDataPage
+
+	Posting
+
+	VerifyToken string
 }
 
 type TemplateDataForm struct {
@@ -79,12 +143,23 @@ type TemplateDataForm struct {
 	Institutes []string
 	Types      []string
 
+	AttachmentLimits
+
+	BotCheck
+
 	AdminToken string
 
 	// `IsEdit` is true if the form is used to edit an existing posting
 	IsEdit bool
 }
 
+type TemplateDataError struct {
+	TemplateDataPage
+
+	// The minutes until requests are allowed again, for 429
+	RetryMinutes int
+}
+
 func handlerIndex(w http.ResponseWriter, r *http.Request) {
 	session, err := sessionStore.Get(r, "s")
 	if err != nil {
@@ -93,38 +168,59 @@ func handlerIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var postings []Posting
-	rows, err := db.Query(`
-SELECT uuid, created_at, category, type, title, text
-FROM postings
-WHERE verified = 1
-    AND deleted = 0
-ORDER BY created_at DESC, id DESC`)
+	lang := requestLang(r)
+
+	filter := parsePostingFilter(r.URL.Query())
+	filter.Lang = lang
+
+	cursor, err := parsePageCursor(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	page, err := listPostings(filter, cursor, config.PageSize)
 	if err != nil {
 		log.Printf("error reading postings from database: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	for rows.Next() {
-		var p Posting
-		if err := rows.Scan(&p.UUID, &p.CreatedAt, &p.Category, &p.Type, &p.Title, &p.Text); err != nil {
-			log.Printf("error scanning posting: %v\n", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		postings = append(postings, p)
+	count, err := countPostings(filter)
+	if err != nil {
+		log.Printf("error counting postings: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	facets, err := listFacets(filter)
+	if err != nil {
+		log.Printf("error reading facets from database: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
 	tmplData := TemplateDataIndex{
 		TemplateDataPage: TemplateDataPage{
 			PageTitle:  "Forschungsarbeitbörse",
 			TitleText:  config.TitleText,
-			InfoText:   template.HTML(config.InfoText),
-			FooterText: template.HTML(config.FooterText),
+			InfoText:   infoText(lang),
+			FooterText: footerText(lang),
 			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
 		},
-		Postings: postings,
+		Postings: page.Postings,
+		Count:    count,
+		Filter:   filter,
+		Facets:   facets,
+	}
+
+	if page.Prev != nil {
+		tmplData.PrevURL = page.Prev.URL(filter)
+	}
+	if page.Next != nil {
+		tmplData.NextURL = page.Next.URL(filter)
 	}
 
 	for _, flash := range session.Flashes() {
@@ -136,7 +232,7 @@ ORDER BY created_at DESC, id DESC`)
 		return
 	}
 
-	if err := tmpl.ExecuteTemplate(w, "index", tmplData); err != nil {
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "index", tmplData); err != nil {
 		log.Printf("error executing template: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -151,26 +247,45 @@ func handlerNew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lang := requestLang(r)
+
 	tmplData := TemplateDataForm{
 		TemplateDataPage: TemplateDataPage{
-			PageTitle:  "Neues Angebot",
+			PageTitle:  tr(lang, "Neues Angebot"),
 			TitleText:  config.TitleText,
-			FooterText: template.HTML(config.FooterText),
+			FooterText: footerText(lang),
 			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
 		},
-		Categories: config.PostingCategories,
-		Types:      config.PostingTypes,
+		Posting:          Posting{Language: lang},
+		Categories:       config.PostingCategories,
+		Types:            config.PostingTypes,
+		AttachmentLimits: attachmentLimits(),
 	}
 
 	if r.Method == "POST" {
-		if err := r.ParseForm(); err != nil {
+		ip := clientIP(r)
+
+		// Checked before reading the form, every posting sends mails
+		if exceeded, retryAfter := mailRateLimiter.exceeded(ip); exceeded {
+			log.Printf("attempt to create posting rejected, too many forms from %q\n", ip)
+			handler429(w, r, retryAfter)
+			return
+		}
+
+		if err := parsePostingForm(w, r); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
 			log.Printf("error parsing form: %v\n", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		uuid := uuid.New().String()
-
+		tmplData.UUID = uuid.New().String()
 		tmplData.Email = r.FormValue("email")
 		tmplData.Title = r.FormValue("title")
 		tmplData.Institute = r.FormValue("institute")
@@ -188,14 +303,38 @@ func handlerNew(w http.ResponseWriter, r *http.Request) {
 		}
 		tmplData.RequiredEffort = r.FormValue("required-effort")
 		tmplData.Text = r.FormValue("text")
+		tmplData.ExpiresOn = r.FormValue("expires-on")
+		tmplData.Translation = readTranslation(r)
+
+		// Bots get the same answer as humans and do not learn about the
+		// honeypot
+		if isHoneypotFilled(r) {
+			log.Printf("attempt to create posting with filled honeypot from %q - ignoring\n", ip)
+			mailRateLimiter.add(ip)
+
+			session.AddFlash(tr(lang, "Angebot gespeichert. Zur Freischaltung bitte Verifizierungslink in E-Mail klicken."))
+			if err := session.Save(r, w); err != nil {
+				log.Printf("error saving session: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, config.URL, http.StatusFound)
+			return
+		}
+
+		if errs := checkBotCheck(lang, r); len(errs) > 0 {
+			log.Printf("bot check of posting from %q failed\n", ip)
+			tmplData.FlashErrors = append(tmplData.FlashErrors, errs...)
+		}
 
 		// For postings from email addresses that are not on
 		// the whitelist admins need to do the verification
 		var requireAdminVerification = false
 
 		if isForbiddenMailAddress(forbiddenMailRegexp, tmplData.Email) {
-			log.Printf("attempt to create posting with forbidden mail address %q - rejecting\n", tmplData.Email)
-			time.Sleep(5 * time.Second) // Be slow and hopefully a little annoying
+			log.Printf("attempt to create posting with forbidden mail address %q from %q - rejecting\n", tmplData.Email, ip)
+			mailRateLimiter.add(ip)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
@@ -204,13 +343,42 @@ func handlerNew(w http.ResponseWriter, r *http.Request) {
 			if errors.Is(err, ErrUnknownEmail) {
 				requireAdminVerification = true
 			} else {
-				tmplData.FlashErrors = append(tmplData.FlashErrors, fmt.Sprintf("Ungültige E-Mail Adresse (%q)", err.Error()))
+				tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Ungültige E-Mail Adresse (%q)", err.Error()))
 			}
 		}
 
 		validateInput(&tmplData)
 
+		uploads, errs, err := readAttachments(lang, r, 0)
+		if err != nil {
+			log.Printf("error reading attachments: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		for _, e := range errs {
+			tmplData.FlashErrors = append(tmplData.FlashErrors, e.Message)
+		}
+
+		if len(tmplData.FlashErrors) == 0 {
+			exceeded, retryAfter, err := emailRateLimitExceeded(tmplData.Email)
+			if err != nil {
+				log.Printf("error counting postings: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if exceeded {
+				log.Printf("attempt to create posting rejected, too many postings from %q\n", tmplData.Email)
+				handler429(w, r, retryAfter)
+				return
+			}
+		}
+
 		if len(tmplData.FlashErrors) > 0 {
+			if len(uploads) > 0 {
+				// Browsers do not send the files again
+				tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Bitte die Anhänge erneut auswählen."))
+			}
 			goto EXEC_TMPL
 		}
 
@@ -228,150 +396,63 @@ func handlerNew(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = db.Exec(`
-INSERT INTO postings (
-    uuid,
-    email,
-    admin_token,
-    verify_token,
-    title,
-    institute,
-    advisor,
-    supervisor,
-    audience,
-    category,
-    type,
-    degree,
-    start,
-    required_months,
-    required_effort,
-    text
-)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			uuid, tmplData.Email, admin_token, verify_token, tmplData.Title, tmplData.Institute,
-			tmplData.Advisor, tmplData.Supervisor, tmplData.Audience, tmplData.Category, tmplData.Type,
-			tmplData.Degree, tmplData.Start, tmplData.RequiredMonths, tmplData.RequiredEffort, tmplData.Text)
+		// The posting and its admin and verification mails are stored
+		// together, the mails are sent in the background
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("error starting transaction: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		err = insertPosting(tx, &tmplData.Posting, admin_token, verify_token, 0)
 		if err != nil {
 			log.Printf("error inserting posting: %v\n", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		// Send admin and verification mails
+		if err := insertAttachments(tx, tmplData.UUID, uploads); err != nil {
+			log.Printf("error inserting attachments: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 
-		mailAuth := smtp.PlainAuth("", config.SMTPUser, config.SMTPPass, config.SMTPHost)
-		mailFrom := config.SMTPMailFrom
+		mailData := newMailData(tmplData.Email, tmplData.UUID, tmplData.Title, admin_token, verify_token)
 
 		if requireAdminVerification {
-			mailTo := []string{config.AdminEmail}
-
-			mailTemplate := tmpl.Lookup("mail-admin.tmpl")
-			if mailTemplate == nil {
-				// This should not happen and be caught during testing
-				log.Fatalf("error looking up mail template: %v\n", err)
-			}
-
-			mailText := new(bytes.Buffer)
-			if err := mailTemplate.Execute(mailText, struct {
-				To          string
-				From        string
-				UUID        string
-				Title       string
-				PreviewLink string
-				VerifyLink  string
-				AdminLink   string
-			}{
-				To:          tmplData.Email,
-				From:        mailFrom,
-				UUID:        uuid,
-				Title:       tmplData.Title,
-				PreviewLink: fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, admin_token),
-				VerifyLink:  fmt.Sprintf("%s/%s/%s/verify", config.URL, uuid, verify_token),
-				AdminLink:   fmt.Sprintf("%s/%s/%s/admin", config.URL, uuid, admin_token),
-			}); err != nil {
-				log.Printf("error executing mail template: %v\n", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-
-			if err := smtp.SendMail(fmt.Sprintf("%s:%s", config.SMTPHost, config.SMTPPort), mailAuth, mailFrom, mailTo, mailText.Bytes()); err != nil {
-				log.Printf("error sending email: %v\n", err)
+			if err := queueMail(tx, []string{config.AdminEmail}, defaultLanguage, "mail-admin.tmpl", mailData); err != nil {
+				log.Printf("error queueing email: %v\n", err)
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
 		}
 
-		mailTo := []string{tmplData.Email}
-
-		mailText := new(bytes.Buffer)
+		mailTemplate := "mail-user-whitelisted.tmpl"
 		if requireAdminVerification {
-			mailTemplate := tmpl.Lookup("mail-user-unknown.tmpl")
-			if mailTemplate == nil {
-				// This should not happen and be caught during testing
-				log.Fatalf("error looking up mail template: %v\n", err)
-			}
-
-			if err := mailTemplate.Execute(mailText, struct {
-				To          string
-				From        string
-				UUID        string
-				Title       string
-				PreviewLink string
-				VerifyLink  string
-				AdminLink   string
-			}{
-				To:          tmplData.Email,
-				From:        mailFrom,
-				UUID:        uuid,
-				Title:       tmplData.Title,
-				PreviewLink: fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, admin_token),
-				VerifyLink:  fmt.Sprintf("%s/%s/%s/verify", config.URL, uuid, verify_token),
-				AdminLink:   fmt.Sprintf("%s/%s/%s/admin", config.URL, uuid, admin_token),
-			}); err != nil {
-				log.Printf("error executing mail template: %v\n", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			mailTemplate := tmpl.Lookup("mail-user-whitelisted.tmpl")
-			if mailTemplate == nil {
-				// This should not happen and be caught during testing
-				log.Fatalf("error looking up mail template: %v\n", err)
-			}
+			mailTemplate = "mail-user-unknown.tmpl"
+		}
 
-			if err := mailTemplate.Execute(mailText, struct {
-				To          string
-				From        string
-				UUID        string
-				Title       string
-				PreviewLink string
-				VerifyLink  string
-				AdminLink   string
-			}{
-				To:          tmplData.Email,
-				From:        mailFrom,
-				UUID:        uuid,
-				Title:       tmplData.Title,
-				PreviewLink: fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, admin_token),
-				VerifyLink:  fmt.Sprintf("%s/%s/%s/verify", config.URL, uuid, verify_token),
-				AdminLink:   fmt.Sprintf("%s/%s/%s/admin", config.URL, uuid, admin_token),
-			}); err != nil {
-				log.Printf("error executing mail template: %v\n", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
+		if err := queueMail(tx, []string{tmplData.Email}, tmplData.Language, mailTemplate, mailData); err != nil {
+			log.Printf("error queueing email: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 
-		mailAddr := fmt.Sprintf("%s:%s", config.SMTPHost, config.SMTPPort)
-		if err := smtp.SendMail(mailAddr, mailAuth, mailFrom, mailTo, mailText.Bytes()); err != nil {
-			log.Printf("error sending email: %v\n", err)
+		if err := tx.Commit(); err != nil {
+			log.Printf("error inserting posting: %v\n", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		flashMessage := "Angebot gespeichert. Zur Freischaltung bitte Verifizierungslink in E-Mail klicken."
+		mailRateLimiter.add(ip)
+
+		wakeOutbox()
+
+		flashMessage := tr(lang, "Angebot gespeichert. Zur Freischaltung bitte Verifizierungslink in E-Mail klicken.")
 		if requireAdminVerification {
-			flashMessage = "Angebot gespeichert. Ihr Angebot wird in Kürze freigeschalten."
+			flashMessage = tr(lang, "Angebot gespeichert. Ihr Angebot wird in Kürze freigeschalten.")
 		}
 
 		session.AddFlash(flashMessage)
@@ -387,6 +468,14 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 
 EXEC_TMPL:
 
+	// Every form gets a new token, the one sent is used up
+	tmplData.BotCheck, err = newBotCheck()
+	if err != nil {
+		log.Printf("error generating form token: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	institutes, err := listInstitutes()
 	if err != nil {
 		log.Printf("error reading institutes from database: %v\n", err)
@@ -396,7 +485,7 @@ EXEC_TMPL:
 
 	tmplData.Institutes = institutes
 
-	if err := tmpl.ExecuteTemplate(w, "form", tmplData); err != nil {
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "form", tmplData); err != nil {
 		log.Printf("error executing template: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -408,15 +497,20 @@ func handlerAdmin(w http.ResponseWriter, r *http.Request) {
 	uuid := vars["uuid"]
 	token := vars["token"]
 
+	lang := requestLang(r)
+
 	tmplData := TemplateDataForm{
 		TemplateDataPage: TemplateDataPage{
 			TitleText:  config.TitleText,
-			FooterText: template.HTML(config.FooterText),
+			FooterText: footerText(lang),
 			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
 		},
-		Categories: config.PostingCategories,
-		Types:      config.PostingTypes,
-		IsEdit:     true,
+		Categories:       config.PostingCategories,
+		Types:            config.PostingTypes,
+		AttachmentLimits: attachmentLimits(),
+		IsEdit:           true,
 	}
 
 	row := db.QueryRow(`
@@ -435,12 +529,22 @@ SELECT
     required_months,
     required_effort,
     text,
-    admin_token
+    coalesce(expires_on, ''),
+    rejected,
+    coalesce(reject_reason, ''),
+    language,
+    translation_language,
+    translation_title,
+    translation_text,
+    admin_token,
+    verify_token
 FROM postings
 WHERE uuid = ?
     AND deleted = 0`,
 		uuid)
 
+	var verifyToken string
+
 	if err := row.Scan(
 		&tmplData.UUID,
 		&tmplData.Email,
@@ -456,7 +560,15 @@ WHERE uuid = ?
 		&tmplData.RequiredMonths,
 		&tmplData.RequiredEffort,
 		&tmplData.Text,
-		&tmplData.AdminToken); err != nil {
+		&tmplData.ExpiresOn,
+		&tmplData.Rejected,
+		&tmplData.RejectReason,
+		&tmplData.Language,
+		&tmplData.Translation.Language,
+		&tmplData.Translation.Title,
+		&tmplData.Translation.Text,
+		&tmplData.AdminToken,
+		&verifyToken); err != nil {
 		if err == sql.ErrNoRows {
 			handler404(w, r)
 			return
@@ -473,8 +585,21 @@ WHERE uuid = ?
 		return
 	}
 
+	attachments, err := listAttachments(uuid, tmplData.AdminToken)
+	if err != nil {
+		log.Printf("error reading attachments of uuid %q: %v\n", uuid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	tmplData.Attachments = attachments
+
 	if r.Method == "POST" {
-		if err := r.ParseForm(); err != nil {
+		if err := parsePostingForm(w, r); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
 			log.Printf("error parsing form: %v\n", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
@@ -498,39 +623,90 @@ WHERE uuid = ?
 		}
 		tmplData.RequiredEffort = r.FormValue("required-effort")
 		tmplData.Text = r.FormValue("text")
+		tmplData.ExpiresOn = r.FormValue("expires-on")
+		tmplData.Translation = readTranslation(r)
 
 		validateInput(&tmplData)
 
+		remove := r.Form["remove-attachments"]
+
+		keep := 0
+		for _, a := range tmplData.Attachments {
+			if !slices.Contains(remove, a.UUID) {
+				keep++
+			}
+		}
+
+		uploads, errs, err := readAttachments(lang, r, keep)
+		if err != nil {
+			log.Printf("error reading attachments: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		for _, e := range errs {
+			tmplData.FlashErrors = append(tmplData.FlashErrors, e.Message)
+		}
+
 		if len(tmplData.FlashErrors) > 0 {
+			if len(uploads) > 0 {
+				// Browsers do not send the files again
+				tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Bitte die Anhänge erneut auswählen."))
+			}
 			goto EXEC_TMPL
 		}
 
-		_, err = db.Exec(`
-UPDATE postings
-SET
-    title = ?,
-    institute = ?,
-    advisor = ?,
-    supervisor = ?,
-    audience = ?,
-    category = ?,
-    type = ?,
-    degree = ?,
-    start = ?,
-    required_months = ?,
-    required_effort = ?,
-    text = ?,
-    last_updated_at = CURRENT_TIMESTAMP
-WHERE uuid = ?`,
-			tmplData.Title, tmplData.Institute, tmplData.Advisor, tmplData.Supervisor, tmplData.Audience,
-			tmplData.Category, tmplData.Type, tmplData.Degree, tmplData.Start, tmplData.RequiredMonths,
-			tmplData.RequiredEffort, tmplData.Text, uuid)
+		// The posting and its attachments are updated together
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("error starting transaction: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		err = updatePosting(tx, &tmplData.Posting)
 		if err != nil {
 			log.Printf("error updating posting with uuid %q: %v\n", uuid, err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
+		removedFiles, err := deleteAttachments(tx, uuid, remove)
+		if err != nil {
+			log.Printf("error deleting attachments of uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err := insertAttachments(tx, uuid, uploads); err != nil {
+			log.Printf("error inserting attachments of uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("error updating posting with uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err := removeAttachmentFiles(removedFiles); err != nil {
+			log.Printf("error removing attachment files of uuid %q: %v\n", uuid, err)
+		}
+
+		if tmplData.Rejected {
+			// The author resubmits a rejected posting, which
+			// needs to be verified by the admins again
+			if err := resubmitPosting(uuid, tmplData.Email, tmplData.Title, tmplData.AdminToken, verifyToken); err != nil {
+				log.Printf("error resubmitting posting with uuid %q: %v\n", uuid, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, tmplData.AdminToken), http.StatusFound)
+			return
+		}
+
 		http.Redirect(w, r, fmt.Sprintf("%s/%s", config.URL, uuid), http.StatusFound)
 		return
 	}
@@ -546,7 +722,7 @@ EXEC_TMPL:
 
 	tmplData.Institutes = institutes
 
-	if err := tmpl.ExecuteTemplate(w, "form", tmplData); err != nil {
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "form", tmplData); err != nil {
 		log.Printf("error executing template: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -565,11 +741,15 @@ func handlerPosting(w http.ResponseWriter, r *http.Request) {
 	uuid := vars["uuid"]
 	token := vars["token"]
 
+	lang := requestLang(r)
+
 	tmplData := TemplateDataPosting{
 		TemplateDataPage: TemplateDataPage{
 			TitleText:  config.TitleText,
-			FooterText: template.HTML(config.FooterText),
+			FooterText: footerText(lang),
 			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
 		},
 		Posting: Posting{},
 	}
@@ -595,6 +775,14 @@ SELECT
     required_months,
     required_effort,
     text,
+    coalesce(expires_on, ''),
+    coalesce(expires_on < date('now'), 0),
+    rejected,
+    coalesce(reject_reason, ''),
+    language,
+    translation_language,
+    translation_title,
+    translation_text,
     admin_token,
     verified
 FROM postings
@@ -617,6 +805,14 @@ WHERE uuid = ?
 		&tmplData.RequiredMonths,
 		&tmplData.RequiredEffort,
 		&tmplData.Text,
+		&tmplData.ExpiresOn,
+		&tmplData.Expired,
+		&tmplData.Rejected,
+		&tmplData.RejectReason,
+		&tmplData.Language,
+		&tmplData.Translation.Language,
+		&tmplData.Translation.Title,
+		&tmplData.Translation.Text,
 		&adminToken,
 		&verified); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -629,9 +825,10 @@ WHERE uuid = ?
 		}
 	}
 
-	if !verified {
-		// This posting is not verified yet, only the admin can see it;
-		// verify it's a valid admin token before showing the preview
+	if !verified || tmplData.Expired {
+		// This posting is not verified yet or expired, only the admin
+		// can see it; verify it's a valid admin token before showing
+		// the preview
 		if token != adminToken {
 			log.Printf("got invalid admin token %q for uuid %q\n", token, uuid)
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
@@ -639,7 +836,83 @@ WHERE uuid = ?
 		}
 	}
 
+	// Link the attachments of a posting which is not public with the
+	// admin token like the preview
+	attachmentToken := ""
+	if !verified || tmplData.Expired {
+		attachmentToken = adminToken
+	}
+
+	tmplData.Attachments, err = listAttachments(uuid, attachmentToken)
+	if err != nil {
+		log.Printf("error reading attachments of uuid %q: %v\n", uuid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	tmplData.UUID = uuid
+	tmplData.Posting = tmplData.Posting.localized(lang)
 	tmplData.PageTitle = tmplData.Title
+	tmplData.ContactForm = verified && !tmplData.Expired
+
+	if r.Method == "POST" {
+		if !tmplData.ContactForm {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		ip := clientIP(r)
+
+		if exceeded, retryAfter := mailRateLimiter.exceeded(ip); exceeded {
+			log.Printf("contact message for uuid %q rejected, too many forms from %q\n", uuid, ip)
+			handler429(w, r, retryAfter)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Printf("error parsing form: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		tmplData.Contact = readContactMessage(r)
+
+		tmplData.FlashErrors = validateContactMessage(lang, tmplData.Contact)
+
+		if len(tmplData.FlashErrors) == 0 {
+			n, err := countContactMessages(tmplData.Contact.Email)
+			if err != nil {
+				log.Printf("error counting contact messages: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if n >= config.ContactMaxPerDay {
+				log.Printf("contact message for uuid %q rejected, %d messages from %q within a day\n", uuid, n, tmplData.Contact.Email)
+				tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Von dieser E-Mail Adresse wurden in den letzten 24 Stunden bereits %d Nachrichten gesendet. Bitte versuchen Sie es später erneut.", n))
+			}
+		}
+
+		if len(tmplData.FlashErrors) == 0 {
+			if err := submitContactMessage(uuid, tmplData.Title, lang, tmplData.Contact); err != nil {
+				log.Printf("error submitting contact message for uuid %q: %v\n", uuid, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			mailRateLimiter.add(ip)
+
+			session.AddFlash(tr(lang, "Bitte bestätigen Sie Ihre E-Mail Adresse über den Link, den wir Ihnen gesendet haben. Erst dann wird Ihre Nachricht weitergeleitet."))
+			if err := session.Save(r, w); err != nil {
+				log.Printf("error saving session: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, fmt.Sprintf("%s/%s", config.URL, uuid), http.StatusFound)
+			return
+		}
+	}
 
 	for _, flash := range session.Flashes() {
 		tmplData.FlashMessages = append(tmplData.FlashMessages, flash.(string))
@@ -650,64 +923,73 @@ WHERE uuid = ?
 		return
 	}
 
-	if err := tmpl.ExecuteTemplate(w, "posting", tmplData); err != nil {
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "posting", tmplData); err != nil {
 		log.Printf("error executing template: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
-func handlerRSSFeed(w http.ResponseWriter, r *http.Request) {
-	now := time.Now()
-	feed := &feeds.Feed{
-		Title:   config.TitleText,
-		Link:    &feeds.Link{Href: config.URL + "/feed"},
-		Created: now,
-	}
-
-	rows, err := db.Query(`
-SELECT uuid, created_at, category, title
-FROM postings
-WHERE verified = 1
-    AND deleted = 0
-ORDER BY created_at DESC, id DESC LIMIT 30`)
+func handlerVerify(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
 	if err != nil {
-		log.Printf("error reading postings from database: %v\n", err)
+		log.Printf("error retrieving session: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	var postings []Posting
-	for rows.Next() {
-		var p Posting
-		if err := rows.Scan(&p.UUID, &p.CreatedAt, &p.Category, &p.Title); err != nil {
-			log.Printf("error scanning posting: %v\n", err)
+	vars := mux.Vars(r)
+
+	uuid := vars["uuid"]
+	token := vars["token"]
+
+	var verifyToken string
+
+	row := db.QueryRow("SELECT verify_token FROM postings WHERE uuid = ?", uuid)
+
+	if err := row.Scan(&verifyToken); err != nil {
+		if err == sql.ErrNoRows {
+			handler404(w, r)
+			return
+		} else {
+			log.Printf("error sql with uuid %q: %v\n", uuid, err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		postings = append(postings, p)
 	}
 
-	for _, p := range postings {
-		feed.Items = append(feed.Items, &feeds.Item{
-			Title:   p.Title,
-			Id:      p.UUID,
-			Link:    &feeds.Link{Href: config.URL + "/" + p.UUID},
-			Created: p.CreatedAt,
-		})
+	if token != verifyToken {
+		log.Printf("got invalid verify token %q for uuid %q\n", token, uuid)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
 	}
 
-	rss, err := feed.ToRss()
+	_, err = db.Exec(`
+UPDATE postings
+SET
+    verified = 1,
+    rejected = 0,
+    last_verified_at = CURRENT_TIMESTAMP,
+    reverify_requested_at = NULL
+WHERE uuid = ?
+    AND verify_token = ?`,
+		uuid, verifyToken)
 	if err != nil {
-		log.Printf("error generating RSS feed: %v\n", err)
+		log.Printf("error verifying posting with uuid %q: %v\n", uuid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	session.AddFlash(tr(requestLang(r), "Angebot freigeschalten."))
+	if err := session.Save(r, w); err != nil {
+		log.Printf("error saving session: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/rss+xml")
-	w.Write([]byte(rss))
+	http.Redirect(w, r, fmt.Sprintf("%s/%s", config.URL, uuid), http.StatusFound)
 }
 
-func handlerVerify(w http.ResponseWriter, r *http.Request) {
+func handlerReject(w http.ResponseWriter, r *http.Request) {
 	session, err := sessionStore.Get(r, "s")
 	if err != nil {
 		log.Printf("error retrieving session: %v\n", err)
@@ -720,11 +1002,25 @@ func handlerVerify(w http.ResponseWriter, r *http.Request) {
 	uuid := vars["uuid"]
 	token := vars["token"]
 
+	lang := requestLang(r)
+
+	tmplData := TemplateDataReject{
+		TemplateDataPage: TemplateDataPage{
+			PageTitle:  tr(lang, "Angebot ablehnen"),
+			TitleText:  config.TitleText,
+			FooterText: footerText(lang),
+			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
+		},
+		VerifyToken: token,
+	}
+
 	var verifyToken string
 
-	row := db.QueryRow("SELECT verify_token FROM postings WHERE uuid = ?", uuid)
+	row := db.QueryRow("SELECT uuid, title, verify_token FROM postings WHERE uuid = ? AND deleted = 0", uuid)
 
-	if err := row.Scan(&verifyToken); err != nil {
+	if err := row.Scan(&tmplData.UUID, &tmplData.Title, &verifyToken); err != nil {
 		if err == sql.ErrNoRows {
 			handler404(w, r)
 			return
@@ -741,24 +1037,102 @@ func handlerVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE postings SET verified = 1, last_verified_at = CURRENT_TIMESTAMP WHERE uuid = ? AND verify_token = ?", uuid, verifyToken)
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			log.Printf("error parsing form: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		tmplData.RejectReason = r.FormValue("reason")
+
+		if tmplData.RejectReason == "" {
+			tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Eine Begründung ist erforderlich."))
+		} else if len(tmplData.RejectReason) > 2000 {
+			tmplData.FlashErrors = append(tmplData.FlashErrors, tr(lang, "Die \"Begründung\" darf maximal 2000 Zeichen lang sein."))
+		}
+
+		if len(tmplData.FlashErrors) > 0 {
+			goto EXEC_TMPL
+		}
+
+		if err := rejectPosting(uuid, tmplData.RejectReason); err != nil {
+			log.Printf("error rejecting posting with uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		session.AddFlash(tr(lang, "Angebot abgelehnt."))
+		if err := session.Save(r, w); err != nil {
+			log.Printf("error saving session: %v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, config.URL, http.StatusFound)
+		return
+	}
+
+EXEC_TMPL:
+
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "reject", tmplData); err != nil {
+		log.Printf("error executing template: %v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func handlerDelete(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionStore.Get(r, "s")
 	if err != nil {
-		log.Printf("error verifying posting with uuid %q: %v\n", uuid, err)
+		log.Printf("error retrieving session: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	session.AddFlash("Angebot freigeschalten.")
+	vars := mux.Vars(r)
+
+	uuid := vars["uuid"]
+	token := vars["token"]
+
+	var adminToken string
+
+	row := db.QueryRow("SELECT admin_token FROM postings WHERE uuid = ?", uuid)
+
+	if err := row.Scan(&adminToken); err != nil {
+		if err == sql.ErrNoRows {
+			handler404(w, r)
+			return
+		} else {
+			log.Printf("error sql with uuid %q: %v\n", uuid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if token != adminToken {
+		log.Printf("got invalid admin token %q for uuid %q\n", token, uuid)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	_, err = db.Exec("UPDATE postings SET deleted = 1, deleted_at = CURRENT_TIMESTAMP WHERE uuid = ? AND admin_token = ?", uuid, adminToken)
+	if err != nil {
+		log.Printf("error soft deleting posting with uuid %q: %v\n", uuid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	session.AddFlash(tr(requestLang(r), "Angebot gelöscht."))
 	if err := session.Save(r, w); err != nil {
 		log.Printf("error saving session: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("%s/%s", config.URL, uuid), http.StatusFound)
+	http.Redirect(w, r, config.URL, http.StatusFound)
 }
 
-func handlerDelete(w http.ResponseWriter, r *http.Request) {
+func handlerExtend(w http.ResponseWriter, r *http.Request) {
 	session, err := sessionStore.Get(r, "s")
 	if err != nil {
 		log.Printf("error retrieving session: %v\n", err)
@@ -773,7 +1147,7 @@ func handlerDelete(w http.ResponseWriter, r *http.Request) {
 
 	var adminToken string
 
-	row := db.QueryRow("SELECT admin_token FROM postings WHERE uuid = ?", uuid)
+	row := db.QueryRow("SELECT admin_token FROM postings WHERE uuid = ? AND deleted = 0", uuid)
 
 	if err := row.Scan(&adminToken); err != nil {
 		if err == sql.ErrNoRows {
@@ -792,34 +1166,54 @@ func handlerDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE postings SET deleted = 1 WHERE uuid = ? AND admin_token = ?", uuid, adminToken)
-	if err != nil {
-		log.Printf("error soft deleting posting with uuid %q: %v\n", uuid, err)
+	// Extend from the current expiry date or, if already expired, from
+	// today; postings without expiry date stay without
+	var expiresOn string
+
+	row = db.QueryRow(`
+UPDATE postings
+SET
+    expires_on = date(max(expires_on, date('now')), ?),
+    expiry_reminded = 0
+WHERE uuid = ?
+    AND admin_token = ?
+    AND expires_on IS NOT NULL
+RETURNING expires_on`,
+		fmt.Sprintf("+%d days", config.ExpiryExtendDays), uuid, adminToken)
+	if err := row.Scan(&expiresOn); errors.Is(err, sql.ErrNoRows) {
+		session.AddFlash(tr(requestLang(r), "Das Angebot hat kein Ablaufdatum und muss nicht verlängert werden."))
+	} else if err != nil {
+		log.Printf("error extending posting with uuid %q: %v\n", uuid, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
+	} else {
+		session.AddFlash(tr(requestLang(r), "Angebot verlängert bis %s.", formatDate(expiresOn)))
 	}
 
-	session.AddFlash("Angebot gelöscht.")
 	if err := session.Save(r, w); err != nil {
 		log.Printf("error saving session: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, config.URL, http.StatusFound)
+	http.Redirect(w, r, fmt.Sprintf("%s/%s/%s/preview", config.URL, uuid, adminToken), http.StatusFound)
 }
 
-func handler404(w http.ResponseWriter, _ *http.Request) {
+func handler404(w http.ResponseWriter, r *http.Request) {
+	lang := requestLang(r)
+
 	tmplData := TemplateDataPosting{
 		TemplateDataPage: TemplateDataPage{
 			TitleText:  config.TitleText,
-			FooterText: template.HTML(config.FooterText),
+			FooterText: footerText(lang),
 			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
 		},
 	}
 
 	w.WriteHeader(http.StatusNotFound)
-	if err := tmpl.ExecuteTemplate(w, "error404", tmplData); err != nil {
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "error404", tmplData); err != nil {
 		log.Printf("error executing template: %v\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -844,56 +1238,128 @@ func listInstitutes() ([]string, error) {
 	return institutes, nil
 }
 
+// validationError is an invalid value of a posting; Field is the name of
+// the field in the API
+type validationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// readTranslation reads the optional second language version from the
+// posting form
+func readTranslation(r *http.Request) PostingTranslation {
+	return PostingTranslation{
+		Language: r.FormValue("translation-language"),
+		Title:    r.FormValue("translation-title"),
+		Text:     r.FormValue("translation-text"),
+	}
+}
+
 func validateInput(tmplData *TemplateDataForm) {
-	if tmplData.Title == "" {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Ein Titel ist erforderlich.")
-	} else if len(tmplData.Title) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Der \"Titel\" darf maximal 500 Zeichen lang sein.")
+	for _, e := range validatePosting(tmplData.Lang, &tmplData.Posting, false) {
+		tmplData.FlashErrors = append(tmplData.FlashErrors, e.Message)
+	}
+}
+
+// validatePosting checks the input of a posting; allowPastExpiry allows
+// expiry dates in the past, with which the API closes postings
+func validatePosting(lang string, p *Posting, allowPastExpiry bool) []validationError {
+	var errs []validationError
+
+	if p.Title == "" {
+		errs = append(errs, validationError{"title", tr(lang, "Ein Titel ist erforderlich.")})
+	} else if len(p.Title) > 500 {
+		errs = append(errs, validationError{"title", tr(lang, "Der \"Titel\" darf maximal 500 Zeichen lang sein.")})
+	} else if hasControlChars(p.Title) {
+		errs = append(errs, validationError{"title", tr(lang, "Der \"Titel\" darf keine Zeilenumbrüche oder Steuerzeichen enthalten.")})
 	}
 
-	if len(tmplData.Institute) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Das Angabe \"Institut\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Institute) > 500 {
+		errs = append(errs, validationError{"institute", tr(lang, "Das Angabe \"Institut\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Advisor) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Betreuerin / Betreuer\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Advisor) > 500 {
+		errs = append(errs, validationError{"advisor", tr(lang, "Die Angabe \"Betreuerin / Betreuer\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Supervisor) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Doktormutter / Doktorvater\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Supervisor) > 500 {
+		errs = append(errs, validationError{"supervisor", tr(lang, "Die Angabe \"Doktormutter / Doktorvater\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Audience) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Für Studierende der Fächer ...\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Audience) > 500 {
+		errs = append(errs, validationError{"audience", tr(lang, "Die Angabe \"Für Studierende der Fächer ...\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Category) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Art\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Category) > 500 {
+		errs = append(errs, validationError{"category", tr(lang, "Die Angabe \"Art\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Type) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Typ\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Type) > 500 {
+		errs = append(errs, validationError{"type", tr(lang, "Die Angabe \"Typ\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Degree) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Abschluss\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Degree) > 500 {
+		errs = append(errs, validationError{"degree", tr(lang, "Die Angabe \"Abschluss\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if len(tmplData.Start) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Start\" darf maximal 500 Zeichen lang sein.")
+	if len(p.Start) > 500 {
+		errs = append(errs, validationError{"start", tr(lang, "Die Angabe \"Start\" darf maximal 500 Zeichen lang sein.")})
 	}
 
-	if tmplData.RequiredMonths < 0 || tmplData.RequiredMonths > 120 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Voraussichtliche Dauer in Monaten\" muss zwischen 0 und 120 Monaten liegen.")
+	if p.RequiredMonths < 0 || p.RequiredMonths > 120 {
+		errs = append(errs, validationError{"required_months", tr(lang, "Die Angabe \"Voraussichtliche Dauer in Monaten\" muss zwischen 0 und 120 Monaten liegen.")})
 	}
 
-	if len(tmplData.RequiredEffort) > 500 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die Angabe \"Ungefährer Arbeitsaufwand\" darf maximal 500 Zeichen lang sein.")
+	if len(p.RequiredEffort) > 500 {
+		errs = append(errs, validationError{"required_effort", tr(lang, "Die Angabe \"Ungefährer Arbeitsaufwand\" darf maximal 500 Zeichen lang sein.")})
+	}
+
+	if p.ExpiresOn != "" {
+		if expiresOn, err := time.Parse("2006-01-02", p.ExpiresOn); err != nil {
+			errs = append(errs, validationError{"expires_on", tr(lang, "Die Angabe \"Sichtbar bis\" ist kein gültiges Datum.")})
+		} else if !allowPastExpiry && expiresOn.Before(time.Now().Truncate(24*time.Hour)) {
+			errs = append(errs, validationError{"expires_on", tr(lang, "Die Angabe \"Sichtbar bis\" darf nicht in der Vergangenheit liegen.")})
+		}
 	}
 
-	if tmplData.Text == "" {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Eine Beschreibung ist erforderlich.")
-	} else if len(tmplData.Text) > 10000 {
-		tmplData.FlashErrors = append(tmplData.FlashErrors, "Die \"Beschreibung\" darf maximal 10000 Zeichen lang sein.")
+	if p.Text == "" {
+		errs = append(errs, validationError{"text", tr(lang, "Eine Beschreibung ist erforderlich.")})
+	} else if len(p.Text) > 10000 {
+		errs = append(errs, validationError{"text", tr(lang, "Die \"Beschreibung\" darf maximal 10000 Zeichen lang sein.")})
 	}
+
+	if p.Language != "" && !isLanguage(p.Language) {
+		errs = append(errs, validationError{"language", tr(lang, "Die Sprache %q wird nicht unterstützt.", p.Language)})
+	}
+
+	if t := p.Translation; t != (PostingTranslation{}) {
+		if t.Language == "" {
+			errs = append(errs, validationError{"translation_language", tr(lang, "Bitte die Sprache der Übersetzung auswählen.")})
+		} else if !isLanguage(t.Language) {
+			errs = append(errs, validationError{"translation_language", tr(lang, "Die Sprache %q wird nicht unterstützt.", t.Language)})
+		} else if t.Language == p.Language {
+			errs = append(errs, validationError{"translation_language", tr(lang, "Die Übersetzung muss in einer anderen Sprache als das Angebot sein.")})
+		}
+
+		if t.Title == "" {
+			errs = append(errs, validationError{"translation_title", tr(lang, "Ein Titel der Übersetzung ist erforderlich.")})
+		} else if len(t.Title) > 500 {
+			errs = append(errs, validationError{"translation_title", tr(lang, "Der \"Titel\" der Übersetzung darf maximal 500 Zeichen lang sein.")})
+		} else if hasControlChars(t.Title) {
+			errs = append(errs, validationError{"translation_title", tr(lang, "Der \"Titel\" der Übersetzung darf keine Zeilenumbrüche oder Steuerzeichen enthalten.")})
+		}
+
+		if t.Text == "" {
+			errs = append(errs, validationError{"translation_text", tr(lang, "Eine Beschreibung der Übersetzung ist erforderlich.")})
+		} else if len(t.Text) > 10000 {
+			errs = append(errs, validationError{"translation_text", tr(lang, "Die \"Beschreibung\" der Übersetzung darf maximal 10000 Zeichen lang sein.")})
+		}
+	}
+	return errs
+}
+
+// hasControlChars reports whether s contains line breaks or other control
+// characters, which single line values like titles must not
+func hasControlChars(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsControl)
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The user interface is written in German. Translations are message
+// catalogs `locales/<lang>.json` mapping the German messages to the
+// translated ones, plus mail templates `mail-*.<lang>.tmpl`; messages
+// missing in a catalog are shown in German.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+const defaultLanguage = "de"
+
+var (
+	// languages are the supported languages, the default language first
+	languages = []string{defaultLanguage}
+
+	catalogs = make(map[string]map[string]string)
+)
+
+// loadCatalogs reads the message catalogs of all languages
+func loadCatalogs() error {
+	names, err := fs.Glob(localeFiles, "locales/*.json")
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		lang := strings.TrimSuffix(path.Base(name), ".json")
+		if lang == defaultLanguage {
+			continue
+		}
+
+		data, err := localeFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("failed to decode message catalog %q: %w", name, err)
+		}
+
+		catalogs[lang] = catalog
+		languages = append(languages, lang)
+	}
+
+	return nil
+}
+
+// tr translates the German message msg into lang; with args the message
+// is a format for `fmt.Sprintf`
+func tr(lang, msg string, args ...any) string {
+	if translated, ok := catalogs[lang][msg]; ok && translated != "" {
+		msg = translated
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+
+	return msg
+}
+
+func isLanguage(lang string) bool {
+	for _, l := range languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLang returns the language chosen with the language switcher or
+// else the preferred language of the browser
+func requestLang(r *http.Request) string {
+	if c, err := r.Cookie("lang"); err == nil && isLanguage(c.Value) {
+		return c.Value
+	}
+
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// acceptLanguage returns the supported language with the highest weight
+// in an `Accept-Language` header, e.g. "en-US,en;q=0.9,de;q=0.8"
+func acceptLanguage(header string) string {
+	lang, weight := defaultLanguage, 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > weight && isLanguage(base) {
+			lang, weight = base, q
+		}
+	}
+
+	return lang
+}
+
+// infoText returns the `info_text` in lang, the untranslated one if
+// there is no translation
+func infoText(lang string) template.HTML {
+	if text, ok := config.InfoTextTranslations[lang]; ok {
+		return template.HTML(text)
+	}
+	return template.HTML(config.InfoText)
+}
+
+// footerText returns the `footer_text` in lang, the untranslated one if
+// there is no translation
+func footerText(lang string) template.HTML {
+	if text, ok := config.FooterTextTranslations[lang]; ok {
+		return template.HTML(text)
+	}
+	return template.HTML(config.FooterText)
+}
+
+// languageLink is a link of the language switcher
+type languageLink struct {
+	Lang     string
+	Name     string
+	URL      string
+	Selected bool
+}
+
+// languageLinks returns the language switcher links leading back to the
+// current page
+func languageLinks(r *http.Request, current string) []languageLink {
+	var links []languageLink
+
+	// Each catalog translates "Deutsch" to the name of its own language
+	for _, lang := range languages {
+		links = append(links, languageLink{
+			Lang:     lang,
+			Name:     tr(lang, "Deutsch"),
+			URL:      "/lang/" + lang + "?redirect=" + url.QueryEscape(r.URL.RequestURI()),
+			Selected: lang == current,
+		})
+	}
+
+	return links
+}
+
+// handlerLanguage remembers the chosen language in a cookie
+func handlerLanguage(w http.ResponseWriter, r *http.Request) {
+	lang := mux.Vars(r)["lang"]
+	if !isLanguage(lang) {
+		handler404(w, r)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "lang",
+		Value:    lang,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only redirect to pages of this site
+	redirect := r.URL.Query().Get("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = "/"
+	}
+
+	http.Redirect(w, r, config.URL+redirect, http.StatusFound)
+}
@@ -1,11 +1,344 @@
 package main
 
-import "log"
+import (
+	"fmt"
+	"log"
+)
 
+// janitorReverify asks the authors of postings verified longer than
+// `reverify_after_days` ago to confirm them again, and hides postings
+// which were not confirmed within `reverify_grace_days`; following the
+// (new) verify link publishes a posting again
 func janitorReverify() {
-	log.Printf("janitor working ...\n")
+	if config.ReverifyAfterDays <= 0 {
+		return
+	}
+
+	type posting struct {
+		id         int64
+		uuid       string
+		email      string
+		title      string
+		adminToken string
+		language   string
+	}
+
+	var postings []posting
+
+	rows, err := db.Query(`
+SELECT id, uuid, email, title, admin_token, language
+FROM postings
+WHERE verified = 1
+    AND deleted = 0
+    AND reverify_requested_at IS NULL
+    AND (expires_on IS NULL OR expires_on >= date('now'))
+    AND coalesce(last_verified_at, created_at) < datetime('now', ?)`,
+		fmt.Sprintf("-%d days", config.ReverifyAfterDays))
+	if err != nil {
+		log.Printf("janitor: error reading postings to reverify: %v\n", err)
+		return
+	}
+
+	for rows.Next() {
+		var p posting
+		if err := rows.Scan(&p.id, &p.uuid, &p.email, &p.title, &p.adminToken, &p.language); err != nil {
+			rows.Close()
+			log.Printf("janitor: error scanning posting: %v\n", err)
+			return
+		}
+		postings = append(postings, p)
+	}
+	rows.Close()
+
+	for _, p := range postings {
+		if err := requestReverify(p.id, p.uuid, p.email, p.title, p.adminToken, p.language); err != nil {
+			log.Printf("janitor: error requesting reverification of posting %q: %v\n", p.uuid, err)
+			continue
+		}
+		log.Printf("janitor: requested reverification of posting %q\n", p.uuid)
+	}
+
+	result, err := db.Exec(`
+UPDATE postings
+SET verified = 0
+WHERE verified = 1
+    AND deleted = 0
+    AND reverify_requested_at < datetime('now', ?)`,
+		fmt.Sprintf("-%d days", config.ReverifyGraceDays))
+	if err != nil {
+		log.Printf("janitor: error hiding postings not reverified: %v\n", err)
+		return
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("janitor: hid %d posting(s) not reverified within %d days\n", n, config.ReverifyGraceDays)
+	}
+}
+
+// requestReverify sets a fresh verify token for the posting and mails it
+// to the author
+func requestReverify(id int64, uuid, email, title, adminToken, lang string) error {
+	verifyToken, err := generateToken(30)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+UPDATE postings
+SET
+    verify_token = ?,
+    reverify_requested_at = CURRENT_TIMESTAMP
+WHERE id = ?`,
+		verifyToken, id); err != nil {
+		return err
+	}
+
+	if err := queueMail(tx, []string{email}, lang, "mail-user-reverify.tmpl", struct {
+		mailData
+		GraceDays int
+	}{
+		mailData:  newMailData(email, uuid, title, adminToken, verifyToken),
+		GraceDays: config.ReverifyGraceDays,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+
+	return nil
 }
 
+// janitorExpiry reminds the authors of postings expiring within
+// `expiry_reminder_days` and sends them a link to extend the posting by
+// `expiry_extend_days`
+func janitorExpiry() {
+	if config.ExpiryReminderDays <= 0 {
+		return
+	}
+
+	type posting struct {
+		id          int64
+		uuid        string
+		email       string
+		title       string
+		adminToken  string
+		verifyToken string
+		expiresOn   string
+		language    string
+	}
+
+	var postings []posting
+
+	rows, err := db.Query(`
+SELECT id, uuid, email, title, admin_token, verify_token, expires_on, language
+FROM postings
+WHERE verified = 1
+    AND deleted = 0
+    AND expiry_reminded = 0
+    AND expires_on >= date('now')
+    AND expires_on <= date('now', ?)`,
+		fmt.Sprintf("+%d days", config.ExpiryReminderDays))
+	if err != nil {
+		log.Printf("janitor: error reading expiring postings: %v\n", err)
+		return
+	}
+
+	for rows.Next() {
+		var p posting
+		if err := rows.Scan(&p.id, &p.uuid, &p.email, &p.title, &p.adminToken, &p.verifyToken, &p.expiresOn, &p.language); err != nil {
+			rows.Close()
+			log.Printf("janitor: error scanning posting: %v\n", err)
+			return
+		}
+		postings = append(postings, p)
+	}
+	rows.Close()
+
+	for _, p := range postings {
+		if err := remindExpiry(p.id, p.uuid, p.email, p.title, p.adminToken, p.verifyToken, p.expiresOn, p.language); err != nil {
+			log.Printf("janitor: error queueing expiry reminder for posting %q: %v\n", p.uuid, err)
+			continue
+		}
+
+		log.Printf("janitor: queued expiry reminder for posting %q\n", p.uuid)
+	}
+
+	wakeOutbox()
+}
+
+// remindExpiry mails the expiry reminder with the extend link to the
+// author and marks the posting as reminded
+func remindExpiry(id int64, uuid, email, title, adminToken, verifyToken, expiresOn, lang string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := queueMail(tx, []string{email}, lang, "mail-user-expiry.tmpl", struct {
+		mailData
+		ExpiresOn  string
+		ExtendLink string
+		ExtendDays int
+	}{
+		mailData:   newMailData(email, uuid, title, adminToken, verifyToken),
+		ExpiresOn:  formatDate(expiresOn),
+		ExtendLink: fmt.Sprintf("%s/%s/%s/extend", config.URL, uuid, adminToken),
+		ExtendDays: config.ExpiryExtendDays,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE postings SET expiry_reminded = 1 WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// janitorCleanup enforces the retention policy: postings never verified
+// are removed after `purge_unverified_after_days`, deleted postings are
+// removed or stripped of personal data after `purge_deleted_after_days`;
+// a value of 0 keeps the postings forever; attachments are removed
+// together with their posting or, in mode "anonymize", its personal data;
+// sent mails are removed after `outboxKeepSentDays`, dead mails after
+// `outboxKeepDeadDays`, contact messages after `contactKeepDays`
 func janitorCleanup() {
-	log.Printf("janitor working ...\n")
+	var purged int64
+
+	result, err := db.Exec(`
+DELETE FROM outbox
+WHERE state = ?
+    AND sent_at < datetime('now', ?)`,
+		outboxSent, fmt.Sprintf("-%d days", outboxKeepSentDays))
+	if err != nil {
+		log.Printf("janitor: error purging sent mails: %v\n", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("janitor: purged %d mail(s) sent more than %d days ago\n", n, outboxKeepSentDays)
+		purged += n
+	}
+
+	result, err = db.Exec(`
+DELETE FROM outbox
+WHERE state = ?
+    AND created_at < datetime('now', ?)`,
+		outboxDead, fmt.Sprintf("-%d days", outboxKeepDeadDays))
+	if err != nil {
+		log.Printf("janitor: error purging dead mails: %v\n", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("janitor: purged %d dead mail(s) older than %d days\n", n, outboxKeepDeadDays)
+		purged += n
+	}
+
+	result, err = db.Exec(`
+DELETE FROM contact_messages
+WHERE created_at < datetime('now', ?)`,
+		fmt.Sprintf("-%d days", contactKeepDays))
+	if err != nil {
+		log.Printf("janitor: error purging contact messages: %v\n", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("janitor: purged %d contact message(s) older than %d days\n", n, contactKeepDays)
+		purged += n
+	}
+
+	if config.PurgeUnverifiedAfterDays > 0 {
+		result, err := db.Exec(`
+DELETE FROM postings
+WHERE verified = 0
+    AND last_verified_at IS NULL
+    AND created_at < datetime('now', ?)`,
+			fmt.Sprintf("-%d days", config.PurgeUnverifiedAfterDays))
+		if err != nil {
+			log.Printf("janitor: error purging unverified postings: %v\n", err)
+			return
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("janitor: purged %d posting(s) never verified within %d days\n", n, config.PurgeUnverifiedAfterDays)
+			purged += n
+		}
+	}
+
+	if config.PurgeDeletedAfterDays > 0 {
+		// Attachments are removed in both modes as they may contain
+		// personal data, too
+		result, err := db.Exec(`
+DELETE FROM attachments
+WHERE posting_id IN (
+    SELECT id
+    FROM postings
+    WHERE deleted = 1
+        AND coalesce(deleted_at, last_updated_at) < datetime('now', ?)
+)`,
+			fmt.Sprintf("-%d days", config.PurgeDeletedAfterDays))
+		if err != nil {
+			log.Printf("janitor: error purging attachments of deleted postings: %v\n", err)
+			return
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("janitor: purged %d attachment(s) of postings deleted more than %d days ago\n", n, config.PurgeDeletedAfterDays)
+			purged += n
+		}
+
+		query := `
+DELETE FROM postings
+WHERE deleted = 1
+    AND coalesce(deleted_at, last_updated_at) < datetime('now', ?)`
+		if config.PurgeDeletedMode == "anonymize" {
+			query = `
+UPDATE postings
+SET
+    email = '',
+    title = '',
+    text = '',
+    translation_title = '',
+    translation_text = '',
+    advisor = '',
+    supervisor = '',
+    reject_reason = ''
+WHERE deleted = 1
+    AND email != ''
+    AND coalesce(deleted_at, last_updated_at) < datetime('now', ?)`
+		}
+
+		result, err = db.Exec(query, fmt.Sprintf("-%d days", config.PurgeDeletedAfterDays))
+		if err != nil {
+			log.Printf("janitor: error purging deleted postings: %v\n", err)
+			return
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("janitor: purged (%s) %d posting(s) deleted more than %d days ago\n", config.PurgeDeletedMode, n, config.PurgeDeletedAfterDays)
+			purged += n
+		}
+	}
+
+	if n, err := sweepAttachmentDir(); err != nil {
+		log.Printf("janitor: error removing files of purged attachments: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: removed %d file(s) of purged attachments\n", n)
+	}
+
+	if purged == 0 {
+		return
+	}
+
+	// Remove the purged data from the database file, too
+	if _, err := db.Exec("VACUUM"); err != nil {
+		log.Printf("janitor: error vacuuming database: %v\n", err)
+	}
 }
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// The facets postings can be filtered by; `Name` is both the query
+// parameter and the column in the `postings` table
+var postingFacets = []struct {
+	Name  string
+	Label string
+}{
+	{"category", "Art"},
+	{"type", "Typ"},
+	{"institute", "Institut"},
+	{"degree", "Abschluss"},
+}
+
+// The moderation states of a posting; only published postings are
+// listed publicly, all others in the admin dashboard
+var postingStates = []struct {
+	Name  string
+	Label string
+	Where string
+}{
+	{"unverified", "Nicht freigeschaltet", "p.verified = 0 AND p.rejected = 0 AND p.deleted = 0"},
+	{"rejected", "Abgelehnt", "p.rejected = 1 AND p.deleted = 0"},
+	{"published", "Veröffentlicht", "p.verified = 1 AND p.deleted = 0 AND (p.expires_on IS NULL OR p.expires_on >= date('now'))"},
+	{"expired", "Abgelaufen", "p.deleted = 0 AND p.expires_on < date('now')"},
+	{"deleted", "Gelöscht", "p.deleted = 1"},
+}
+
+const statePublished = "published"
+
+// The orders a listing can be sorted in; `Expr` is the SQL expression
+// sorted by, with the posting id as tie breaker
+var postingSorts = []postingSort{
+	{"new", "Neueste zuerst", "p.created_at || ''", true},
+	{"updated", "Zuletzt aktualisiert", "coalesce(p.last_updated_at, p.created_at) || ''", true},
+	// Postings starting "sofort" (or without a date in the format
+	// dd.mm.yyyy) come first
+	{"start", "Startdatum", `CASE
+        WHEN p.start GLOB '[0-9][0-9].[0-9][0-9].[0-9][0-9][0-9][0-9]'
+        THEN substr(p.start, 7, 4) || substr(p.start, 4, 2) || substr(p.start, 1, 2)
+        ELSE ''
+    END`, false},
+	{"duration", "Dauer", "coalesce(p.required_months, 0)", false},
+}
+
+// The sort order of search results, only available when searching
+var postingSortRelevance = postingSort{
+	"relevance", "Relevanz", "bm25(postings_fts, 10.0, 1.0, 5.0, 3.0, 3.0, 2.0, 10.0, 1.0)", false,
+}
+
+type postingSort struct {
+	Name  string
+	Label string
+	Expr  string
+	Desc  bool
+}
+
+// postingPage is one page of a listing with the cursors of the adjacent
+// pages, if any
+type postingPage struct {
+	Postings []Posting
+
+	Prev *pageCursor
+	Next *pageCursor
+}
+
+// pageCursor marks the position of a posting in a listing for keyset
+// pagination
+type pageCursor struct {
+	// The value of the sort expression and the id of the posting
+	Key any
+	ID  int64
+
+	// Before is true if the page ends before the posting, otherwise
+	// it starts after it
+	Before bool
+}
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// parsePageCursor reads the cursor from the `after` or `before` query
+// parameter; the zero cursor denotes the first page
+func parsePageCursor(v url.Values) (pageCursor, error) {
+	var c pageCursor
+
+	s := v.Get("after")
+	if s == "" {
+		s = v.Get("before")
+		c.Before = true
+	}
+	if s == "" {
+		return pageCursor{}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	var key []any
+	if err := json.Unmarshal(data, &key); err != nil || len(key) != 2 {
+		return c, ErrInvalidCursor
+	}
+
+	id, ok := key[1].(float64)
+	if !ok {
+		return c, ErrInvalidCursor
+	}
+
+	switch key[0].(type) {
+	case string, float64:
+	default:
+		return c, ErrInvalidCursor
+	}
+
+	c.Key, c.ID = key[0], int64(id)
+
+	return c, nil
+}
+
+func (c pageCursor) isSet() bool {
+	return c.ID != 0
+}
+
+// URL returns the listing URL for the filter starting at the cursor
+func (c pageCursor) URL(f postingFilter) string {
+	v := f.values()
+	c.set(v)
+	return f.path() + "?" + v.Encode()
+}
+
+// set sets the `after` or `before` query parameter to the cursor
+func (c pageCursor) set(v url.Values) {
+	key := c.Key
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+
+	data, err := json.Marshal([]any{key, c.ID})
+	if err != nil {
+		// Keys are strings or numbers, which always marshal
+		panic(err)
+	}
+
+	if c.Before {
+		v.Set("before", base64.RawURLEncoding.EncodeToString(data))
+	} else {
+		v.Set("after", base64.RawURLEncoding.EncodeToString(data))
+	}
+}
+
+type Facet struct {
+	Name   string
+	Label  string
+	Values []FacetValue
+}
+
+type FacetValue struct {
+	Value    string
+	Count    int
+	Selected bool
+
+	// Link to the listing with this value toggled
+	URL string
+}
+
+// postingFilter selects the postings shown in a listing
+type postingFilter struct {
+	// Moderation state of the postings, see `postingStates`; only
+	// the admin dashboard lists other than published postings
+	State string
+	Admin bool
+
+	// Free text search term
+	Query string
+
+	// Selected facet values by facet name
+	Facets map[string]string
+
+	// Name of the sort order, see `postingSorts`
+	Sort string
+
+	// UUID restricts the listing to a single posting, if set
+	UUID string
+
+	// Full is true if the postings are listed with their whole text,
+	// otherwise only the beginning is loaded
+	Full bool
+
+	// Lang is the language the postings are shown in, postings
+	// translated into it are listed with the translated title and
+	// text (see `Posting.localized`); empty for the original ones
+	Lang string
+}
+
+func parsePostingFilter(v url.Values) postingFilter {
+	f := postingFilter{
+		State:  statePublished,
+		Query:  strings.TrimSpace(v.Get("q")),
+		Facets: make(map[string]string),
+	}
+
+	for _, facet := range postingFacets {
+		if value := v.Get(facet.Name); value != "" {
+			f.Facets[facet.Name] = value
+		}
+	}
+
+	for _, sort := range f.Sorts() {
+		if sort.Name == v.Get("sort") {
+			f.Sort = sort.Name
+		}
+	}
+
+	return f
+}
+
+// parseAdminFilter reads the filter of the admin dashboard, which lists
+// postings of any state, by default those waiting for verification
+func parseAdminFilter(v url.Values) postingFilter {
+	f := parsePostingFilter(v)
+	f.Admin = true
+	f.State = postingStates[0].Name
+
+	for _, state := range postingStates {
+		if state.Name == v.Get("state") {
+			f.State = state.Name
+		}
+	}
+
+	return f
+}
+
+// withState returns a copy of the filter for another moderation state
+func (f postingFilter) withState(state string) postingFilter {
+	f.State = state
+	return f
+}
+
+// URL returns the first page of the listing
+func (f postingFilter) URL() string {
+	v := f.values()
+	if len(v) == 0 {
+		return f.path()
+	}
+	return f.path() + "?" + v.Encode()
+}
+
+// FeedURL returns the feed of the listing in the given format, one of
+// "rss", "atom" or "json"
+func (f postingFilter) FeedURL(format string) string {
+	path := "/feed"
+	if format != "rss" {
+		path += "." + format
+	}
+	v := f.values()
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+func (f postingFilter) path() string {
+	if f.Admin {
+		return "/admin"
+	}
+	return "/"
+}
+
+// Sorts returns the sort orders available for the filter
+func (f postingFilter) Sorts() []postingSort {
+	if f.isSearch() {
+		return append([]postingSort{postingSortRelevance}, postingSorts...)
+	}
+	return postingSorts
+}
+
+// sort returns the selected sort order, by default search results are
+// sorted by relevance and everything else by date
+func (f postingFilter) sort() postingSort {
+	sorts := f.Sorts()
+	for _, sort := range sorts {
+		if sort.Name == f.Sort {
+			return sort
+		}
+	}
+	return sorts[0]
+}
+
+// IsSet is true if the filter restricts the listing in any way
+func (f postingFilter) IsSet() bool {
+	return f.Query != "" || len(f.Facets) > 0
+}
+
+func (f postingFilter) values() url.Values {
+	v := url.Values{}
+	if f.Admin {
+		v.Set("state", f.State)
+	}
+	if f.Query != "" {
+		v.Set("q", f.Query)
+	}
+	for name, value := range f.Facets {
+		v.Set(name, value)
+	}
+	if f.Sort != "" {
+		v.Set("sort", f.Sort)
+	}
+	return v
+}
+
+// toggleURL returns the index URL with facet `name` set to `value`, or
+// unset if it already has that value
+func (f postingFilter) toggleURL(name, value string) string {
+	v := f.values()
+	if f.Facets[name] == value {
+		v.Del(name)
+	} else {
+		v.Set(name, value)
+	}
+	if len(v) == 0 {
+		return f.path()
+	}
+	return f.path() + "?" + v.Encode()
+}
+
+func (f postingFilter) isSearch() bool {
+	return ftsQuery(f.Query) != ""
+}
+
+// from returns the FROM clause for the filter, joining the search index
+// for searches
+func (f postingFilter) from() string {
+	if f.isSearch() {
+		return "FROM postings p JOIN postings_fts ON postings_fts.rowid = p.id"
+	}
+	return "FROM postings p"
+}
+
+// where returns the WHERE clause and its arguments for the filter; the
+// facet named `skip` is left out, which is used for counting the values
+// of that facet
+func (f postingFilter) where(skip string) (string, []any) {
+	var (
+		clauses []string
+		args    []any
+	)
+
+	for _, state := range postingStates {
+		if state.Name == f.State {
+			clauses = append(clauses, state.Where)
+		}
+	}
+	if len(clauses) == 0 {
+		// Never list anything but published postings by accident
+		clauses = append(clauses, "0")
+	}
+
+	if f.UUID != "" {
+		clauses = append(clauses, "p.uuid = ?")
+		args = append(args, f.UUID)
+	}
+
+	if f.isSearch() {
+		clauses = append(clauses, "postings_fts MATCH ?")
+		args = append(args, ftsQuery(f.Query))
+	}
+
+	for _, facet := range postingFacets {
+		value, ok := f.Facets[facet.Name]
+		if !ok || facet.Name == skip {
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf("p.%s = ?", facet.Name))
+		args = append(args, value)
+	}
+
+	return "WHERE " + strings.Join(clauses, "\n    AND "), args
+}
+
+// listPostings returns a page of at most `limit` postings matching the
+// filter, in the filter's sort order, starting after (or ending before)
+// the given cursor; search results carry highlighted excerpts
+func listPostings(f postingFilter, c pageCursor, limit int) (postingPage, error) {
+	var page postingPage
+
+	sort := f.sort()
+
+	// The beginning of the Markdown text is enough for the excerpts of
+	// 200 characters of plain text, search results show the matches
+	// anywhere in the text
+	text, translationText := "substr(p.text, 1, 500)", "substr(p.translation_text, 1, 500)"
+	if f.Full || f.isSearch() {
+		text, translationText = "p.text", "p.translation_text"
+	}
+
+	columns := "p.id, " + sort.Expr + ", p.uuid, p.created_at, p.last_updated_at, " +
+		"p.email, p.title, p.institute, p.advisor, p.supervisor, p.audience, p.category, p.type, p.degree, " +
+		"p.start, p.required_months, p.required_effort, " + text + ", coalesce(p.expires_on, ''), " +
+		"p.verified, p.deleted, p.rejected, coalesce(p.expires_on < date('now'), 0), p.language, " +
+		"p.translation_language, p.translation_title, " + translationText
+
+	var args []any
+
+	if f.isSearch() {
+		// Highlight the matches in the title shown, like
+		// `Posting.localized` chooses it; the excerpt of the text is
+		// highlighted by `searchExcerpt`
+		localized := "p.translation_language != '' AND p.translation_language = ? AND p.language != ?"
+		columns += `,
+    CASE WHEN ` + localized + `
+        THEN highlight(postings_fts, 6, char(2), char(3))
+        ELSE highlight(postings_fts, 0, char(2), char(3))
+    END`
+		args = append(args, f.Lang, f.Lang)
+	} else {
+		columns += ", ''"
+	}
+
+	where, whereArgs := f.where("")
+	args = append(args, whereArgs...)
+
+	// Paging backwards is done by reversing the sort order and the
+	// resulting rows
+	desc := sort.Desc != c.Before
+
+	if c.isSet() {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where += fmt.Sprintf("\n    AND (%s, p.id) %s (?, ?)", sort.Expr, op)
+		args = append(args, c.Key, c.ID)
+	}
+
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+
+	// Fetch one more row than requested to find out if there are more
+	// pages in this direction
+	args = append(args, limit+1)
+
+	rows, err := db.Query(fmt.Sprintf(`
+SELECT %[1]s
+%[2]s
+%[3]s
+ORDER BY %[4]s %[5]s, p.id %[5]s
+LIMIT ?`, columns, f.from(), where, sort.Expr, order), args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var cursors []pageCursor
+
+	for rows.Next() {
+		var (
+			p Posting
+			c pageCursor
+		)
+		if err := rows.Scan(&c.ID, &c.Key, &p.UUID, &p.CreatedAt, &p.LastUpdatedAt, &p.Email, &p.Title, &p.Institute,
+			&p.Advisor, &p.Supervisor, &p.Audience, &p.Category, &p.Type, &p.Degree, &p.Start, &p.RequiredMonths,
+			&p.RequiredEffort, &p.Text, &p.ExpiresOn, &p.Verified, &p.Deleted, &p.Rejected, &p.Expired, &p.Language,
+			&p.Translation.Language, &p.Translation.Title, &p.Translation.Text,
+			&p.TitleHighlight); err != nil {
+			return page, err
+		}
+		p = p.localized(f.Lang)
+		if f.isSearch() {
+			p.TextHighlight = searchExcerpt(plainText(p.Text), f.Query)
+		}
+		page.Postings = append(page.Postings, p)
+		cursors = append(cursors, c)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	more := len(page.Postings) > limit
+	if more {
+		page.Postings = page.Postings[:limit]
+		cursors = cursors[:limit]
+	}
+
+	if c.Before {
+		slices.Reverse(page.Postings)
+		slices.Reverse(cursors)
+	}
+
+	if len(cursors) == 0 {
+		return page, nil
+	}
+
+	first, last := cursors[0], cursors[len(cursors)-1]
+	first.Before = true
+
+	if c.Before {
+		page.Next = &last
+		if more {
+			page.Prev = &first
+		}
+	} else {
+		if more {
+			page.Next = &last
+		}
+		if c.isSet() {
+			page.Prev = &first
+		}
+	}
+
+	return page, nil
+}
+
+// getPublishedPosting returns the published posting with the given
+// uuid or `sql.ErrNoRows`
+func getPublishedPosting(uuid string) (Posting, error) {
+	page, err := listPostings(postingFilter{State: statePublished, UUID: uuid, Full: true}, pageCursor{}, 1)
+	if err != nil {
+		return Posting{}, err
+	}
+	if len(page.Postings) == 0 {
+		return Posting{}, sql.ErrNoRows
+	}
+	return page.Postings[0], nil
+}
+
+// countPostings returns the number of postings matching the filter
+func countPostings(f postingFilter) (int, error) {
+	var count int
+
+	where, args := f.where("")
+
+	row := db.QueryRow(fmt.Sprintf(`
+SELECT COUNT(*)
+%s
+%s`, f.from(), where), args...)
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// listFacets returns the values of all facets with the number of postings
+// each would yield, taking the other selected facets into account
+func listFacets(f postingFilter) ([]Facet, error) {
+	var facets []Facet
+
+	for _, facet := range postingFacets {
+		where, args := f.where(facet.Name)
+
+		rows, err := db.Query(fmt.Sprintf(`
+SELECT p.%[1]s, COUNT(*)
+%[2]s
+%[3]s
+    AND p.%[1]s != ''
+GROUP BY p.%[1]s
+ORDER BY COUNT(*) DESC, p.%[1]s`, facet.Name, f.from(), where), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		result := Facet{Name: facet.Name, Label: facet.Label}
+		for rows.Next() {
+			var v FacetValue
+			if err := rows.Scan(&v.Value, &v.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			v.Selected = f.Facets[facet.Name] == v.Value
+			v.URL = f.toggleURL(facet.Name, v.Value)
+			result.Values = append(result.Values, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if value, ok := f.Facets[facet.Name]; ok && !hasFacetValue(result.Values, value) {
+			// Keep a selected value without results visible so it
+			// can be deselected
+			result.Values = append(result.Values, FacetValue{
+				Value:    value,
+				Selected: true,
+				URL:      f.toggleURL(facet.Name, value),
+			})
+		}
+
+		facets = append(facets, result)
+	}
+
+	return facets, nil
+}
+
+func hasFacetValue(values []FacetValue, value string) bool {
+	for _, v := range values {
+		if v.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestPageCursor(t *testing.T) {
+	tests := []pageCursor{
+		{Key: "2024-05-01 12:00:00", ID: 42},
+		{Key: "Robotik", ID: 7, Before: true},
+		{Key: float64(3), ID: 1},
+		{Key: "", ID: 9007199254740991},
+	}
+
+	for _, c := range tests {
+		v := cursorQuery(t, c)
+
+		got, err := parsePageCursor(v)
+		if err != nil {
+			t.Errorf("parsePageCursor(%v) for %+v: %v", v, c, err)
+			continue
+		}
+		if got != c {
+			t.Errorf("parsePageCursor(%v) = %+v, want %+v", v, got, c)
+		}
+	}
+
+	// Keys scanned from the database as bytes are encoded as strings
+	v := cursorQuery(t, pageCursor{Key: []byte("Titel"), ID: 3})
+	if got, err := parsePageCursor(v); err != nil || got != (pageCursor{Key: "Titel", ID: 3}) {
+		t.Errorf("parsePageCursor(%v) = %+v, %v", v, got, err)
+	}
+}
+
+// cursorQuery returns the query of the listing URL for the cursor
+func cursorQuery(t *testing.T, c pageCursor) url.Values {
+	t.Helper()
+
+	u, err := url.Parse(c.URL(postingFilter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestParsePageCursor(t *testing.T) {
+	tests := []struct {
+		query string
+		want  pageCursor
+		err   error
+	}{
+		{"", pageCursor{}, nil},
+		{"after=", pageCursor{}, nil},
+		{"after=WyJhIiwxXQ", pageCursor{Key: "a", ID: 1}, nil},
+		{"before=WyJhIiwxXQ", pageCursor{Key: "a", ID: 1, Before: true}, nil},
+		{"after=WyJhIiwxXQ&before=WzEsMl0", pageCursor{Key: "a", ID: 1}, nil},
+		{"after=!!!", pageCursor{}, ErrInvalidCursor},
+		{"after=bm90IGpzb24", pageCursor{}, ErrInvalidCursor},  // not json
+		{"after=WyJhIl0", pageCursor{}, ErrInvalidCursor},      // ["a"]
+		{"after=WyJhIiwiYiJd", pageCursor{}, ErrInvalidCursor}, // ["a","b"]
+		{"after=W251bGwsMV0", pageCursor{}, ErrInvalidCursor},  // [null,1]
+		{"after=W1tdLDFd", pageCursor{}, ErrInvalidCursor},     // [[],1]
+	}
+
+	for _, tt := range tests {
+		v, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := parsePageCursor(v)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("parsePageCursor(%q) error = %v, want %v", tt.query, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parsePageCursor(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
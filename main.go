@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -36,16 +37,25 @@ var (
 type Config struct {
 	Addr string
 
-	AdminEmail string `toml:"admin_email"`
+	AdminEmail    string `toml:"admin_email"`
+	AdminPassword string `toml:"admin_password"`
 
 	CookieSecret string `toml:"cookie_secret"`
 
 	DBPath string
 
+	TemplateDir string `toml:"template_dir"`
+
+	AutoMigrate bool `toml:"auto_migrate"`
+
 	TitleText  string `toml:"title_text"`
 	FooterText string `toml:"footer_text"`
 	InfoText   string `toml:"info_text"`
 
+	// Translations of `footer_text` and `info_text` by language
+	FooterTextTranslations map[string]string `toml:"footer_text_translations"`
+	InfoTextTranslations   map[string]string `toml:"info_text_translations"`
+
 	PostingCategories []string `toml:"posting_categories"`
 	PostingTypes      []string `toml:"posting_types"`
 
@@ -54,24 +64,102 @@ type Config struct {
 	SMTPPass     string `toml:"smtp_pass"`
 	SMTPPort     string `toml:"smtp_port"`
 	SMTPUser     string `toml:"smtp_user"`
+	SMTPSecurity string `toml:"smtp_security"`
+	SMTPAuth     string `toml:"smtp_auth"`
+	SMTPCAFile   string `toml:"smtp_ca_file"`
+	SMTPHELO     string `toml:"smtp_helo"`
+	SMTPTimeout  int    `toml:"smtp_timeout"`
+
+	MailTransport string `toml:"mail_transport"`
+	SendmailPath  string `toml:"sendmail_path"`
+	MailDir       string `toml:"mail_dir"`
+
+	DKIMDomain   string `toml:"dkim_domain"`
+	DKIMSelector string `toml:"dkim_selector"`
+	DKIMKeyFile  string `toml:"dkim_key_file"`
+
+	MailMaxAttempts int `toml:"mail_max_attempts"`
 
-	URL string `toml:"url`
+	URL string `toml:"url"`
 
 	ValidMailRegexp     []string `toml:"valid_mail_regexp"`
 	ForbiddenMailRegexp []string `toml:"forbidden_mail_regexp"`
 
 	JanitorInterval int `toml:"janitor_interval"`
+
+	ReverifyAfterDays int `toml:"reverify_after_days"`
+	ReverifyGraceDays int `toml:"reverify_grace_days"`
+
+	ExpiryReminderDays int `toml:"expiry_reminder_days"`
+	ExpiryExtendDays   int `toml:"expiry_extend_days"`
+
+	PurgeUnverifiedAfterDays int    `toml:"purge_unverified_after_days"`
+	PurgeDeletedAfterDays    int    `toml:"purge_deleted_after_days"`
+	PurgeDeletedMode         string `toml:"purge_deleted_mode"`
+
+	PageSize int `toml:"page_size"`
+
+	AttachmentMaxCount int      `toml:"attachment_max_count"`
+	AttachmentMaxSize  int64    `toml:"attachment_max_size"`
+	AttachmentTypes    []string `toml:"attachment_types"`
+	AttachmentStorage  string   `toml:"attachment_storage"`
+	AttachmentDir      string   `toml:"attachment_dir"`
+
+	ContactMaxPerDay int `toml:"contact_max_per_day"`
+
+	RateLimitMailPerIP     int      `toml:"rate_limit_mail_per_ip"`
+	RateLimitNewPerEmail   int      `toml:"rate_limit_new_per_email"`
+	RateLimitTokenFailures int      `toml:"rate_limit_token_failures"`
+	TrustedProxies         []string `toml:"trusted_proxies"`
+
+	FormMinSeconds    int `toml:"form_min_seconds"`
+	FormPowDifficulty int `toml:"form_pow_difficulty"`
+
+	APICORSOrigins []string `toml:"api_cors_origins"`
 }
 
 func main() {
 	var (
-		err    error
-		initDb bool
+		err       error
+		initDb    bool
+		migrateDb bool
+
+		createAPIKeyName  string
+		apiKeyEmailRegexp string
+		listAPIKeys       bool
+		revokeAPIKeyID    int64
+
+		listMail     bool
+		resendMailID int64
+		testMailTo   string
 	)
 
 	config.Addr = "127.0.0.1:8080"
 	config.DBPath = "./forschungsarbeitboerse.sqlite3"
+	config.AutoMigrate = true
 	config.JanitorInterval = 600
+	config.MailTransport = "smtp"
+	config.SMTPSecurity = "auto"
+	config.SMTPTimeout = 30
+	config.SendmailPath = "/usr/sbin/sendmail"
+	config.MailDir = "./mail"
+	config.MailMaxAttempts = 10
+	config.PageSize = 20
+	config.AttachmentMaxCount = 3
+	config.AttachmentMaxSize = 5 << 20
+	config.AttachmentTypes = []string{"application/pdf", "image/png", "image/jpeg"}
+	config.AttachmentStorage = "database"
+	config.AttachmentDir = "./attachments"
+	config.ContactMaxPerDay = 5
+	config.RateLimitMailPerIP = 10
+	config.RateLimitNewPerEmail = 5
+	config.RateLimitTokenFailures = 20
+	config.FormMinSeconds = 5
+	config.APICORSOrigins = []string{"*"}
+	config.ReverifyGraceDays = 14
+	config.ExpiryReminderDays = 7
+	config.ExpiryExtendDays = 90
+	config.PurgeDeletedMode = "delete"
 
 	flag.StringVar(&configPath, "config", "./forschungsarbeitboerse.toml", "path to config file")
 	flag.BoolFunc("version", "print version and exit", func(s string) error {
@@ -86,6 +174,14 @@ func main() {
 		return nil
 	})
 	flag.BoolVar(&initDb, "init-db", false, "initialize database and exit")
+	flag.BoolVar(&migrateDb, "migrate", false, "upgrade database schema and exit")
+	flag.StringVar(&createAPIKeyName, "create-api-key", "", "create API key with the given name, print it and exit")
+	flag.StringVar(&apiKeyEmailRegexp, "api-key-email-regexp", "", "email addresses the API key created with -create-api-key may post for (RegExp matching the whole address)")
+	flag.BoolVar(&listAPIKeys, "list-api-keys", false, "list API keys and exit")
+	flag.Int64Var(&revokeAPIKeyID, "revoke-api-key", 0, "revoke API key with the given id and exit")
+	flag.BoolVar(&listMail, "list-mail", false, "list mails not sent yet and exit")
+	flag.Int64Var(&resendMailID, "resend-mail", 0, "queue mail with the given id for sending again and exit")
+	flag.StringVar(&testMailTo, "test-mail", "", "send test mail to the given address, print the SMTP dialogue and exit")
 
 	flag.Parse()
 
@@ -106,26 +202,152 @@ func main() {
 		log.Fatalf("failed to stat config file %q: %v\n", configPath, err)
 	}
 
+	if err := loadCatalogs(); err != nil {
+		log.Fatalf("failed to load message catalogs: %v\n", err)
+	}
+
+	if err := loadTemplates(config.TemplateDir); err != nil {
+		log.Fatalf("failed to load templates: %v\n", err)
+	}
+
 	db, err = sql.Open("sqlite3", config.DBPath)
 	if err != nil {
 		log.Fatalf("failed to open database: %v\n", err)
 	}
 
-	if initDb {
-		tmplInitSql := tmpl.Lookup("init.sql")
-		if tmplInitSql == nil {
-			log.Fatalf("failed to find init.sql in assets\n")
+	if initDb || migrateDb {
+		if err := runMigrations(); err != nil {
+			log.Fatalf("failed to migrate database: %v\n", err)
 		}
-		tmplBuf := new(bytes.Buffer)
-		if err := tmplInitSql.Execute(tmplBuf, nil); err != nil {
-			log.Fatalf("failed to execute init.sql template: %v\n", err)
+		return
+	}
+
+	if err := checkSchema(); err != nil {
+		log.Fatalf("failed to check database schema: %v\n", err)
+	}
+
+	if createAPIKeyName != "" {
+		key, err := createAPIKey(createAPIKeyName, apiKeyEmailRegexp)
+		if err != nil {
+			log.Fatalf("failed to create API key: %v\n", err)
+		}
+		fmt.Printf("%s\n", key)
+		return
+	}
+
+	if listAPIKeys {
+		if err := printAPIKeys(); err != nil {
+			log.Fatalf("failed to list API keys: %v\n", err)
+		}
+		return
+	}
+
+	if revokeAPIKeyID != 0 {
+		if err := revokeAPIKey(revokeAPIKeyID); err != nil {
+			log.Fatalf("failed to revoke API key: %v\n", err)
+		}
+		log.Printf("revoked API key %d\n", revokeAPIKeyID)
+		return
+	}
+
+	if listMail {
+		if err := printOutbox(); err != nil {
+			log.Fatalf("failed to list mails: %v\n", err)
+		}
+		return
+	}
+
+	if resendMailID != 0 {
+		if err := resendMail(resendMailID); errors.Is(err, sql.ErrNoRows) {
+			log.Fatalf("failed to resend mail: no unsent mail with id %d\n", resendMailID)
+		} else if err != nil {
+			log.Fatalf("failed to resend mail: %v\n", err)
+		}
+		log.Printf("queued mail %d for sending again\n", resendMailID)
+		return
+	}
+
+	transport, err = newMailTransport(config)
+	if err != nil {
+		log.Fatalf("failed to set up mail transport: %v\n", err)
+	}
+
+	dkimOptions, err = newDKIMOptions(config)
+	if err != nil {
+		log.Fatalf("failed to set up DKIM signing: %v\n", err)
+	}
+	if dkimOptions != nil {
+		if _, domain, _ := strings.Cut(config.SMTPMailFrom, "@"); !strings.EqualFold(domain, config.DKIMDomain) {
+			log.Printf("warning: DKIM domain %q does not match the domain of smtp_mail_from %q\n", config.DKIMDomain, config.SMTPMailFrom)
 		}
-		if _, err := db.Exec(tmplBuf.String()); err != nil {
-			log.Fatalf("failed to execute init database: %v\n", err)
+		log.Printf("signing mails with DKIM selector %q of domain %q\n", config.DKIMSelector, config.DKIMDomain)
+	}
+
+	if testMailTo != "" {
+		if err := sendTestMail(testMailTo); err != nil {
+			log.Fatalf("failed to send test mail: %v\n", err)
 		}
-		log.Printf("initialized database at %q\n", config.DBPath)
+		log.Printf("sent test mail to %q\n", testMailTo)
 		return
+	}
+
+	if config.MailMaxAttempts < 1 {
+		log.Fatalf("mail max attempts must be at least 1\n")
+	}
+
+	if config.PurgeDeletedMode != "delete" && config.PurgeDeletedMode != "anonymize" {
+		log.Fatalf("purge deleted mode must be \"delete\" or \"anonymize\", got %q\n", config.PurgeDeletedMode)
+	}
+
+	if config.ReverifyGraceDays < 1 {
+		log.Fatalf("reverify grace days must be at least 1\n")
+	}
+
+	if config.ExpiryExtendDays < 1 {
+		log.Fatalf("expiry extend days must be at least 1\n")
+	}
+
+	if config.PageSize < 1 {
+		log.Fatalf("page size must be at least 1\n")
+	}
+
+	if config.ContactMaxPerDay < 1 {
+		log.Fatalf("contact max per day must be at least 1\n")
+	}
 
+	if config.RateLimitMailPerIP < 0 || config.RateLimitNewPerEmail < 0 || config.RateLimitTokenFailures < 0 {
+		log.Fatalf("rate limits must not be negative\n")
+	}
+
+	mailRateLimiter = newRateLimiter(config.RateLimitMailPerIP, time.Hour)
+	tokenRateLimiter = newRateLimiter(config.RateLimitTokenFailures, time.Hour)
+
+	trustedProxies, err = parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("got invalid trusted proxy: %v\n", err)
+	}
+
+	if config.FormMinSeconds < 0 {
+		log.Fatalf("form min seconds must not be negative\n")
+	}
+
+	// More bits take browsers too long, each bit doubles the work
+	if config.FormPowDifficulty < 0 || config.FormPowDifficulty > 24 {
+		log.Fatalf("form proof of work difficulty must be between 0 and 24, got %d\n", config.FormPowDifficulty)
+	}
+
+	if config.AttachmentMaxCount > 0 && config.AttachmentMaxSize < 1 {
+		log.Fatalf("attachment max size must be at least 1 byte\n")
+	}
+
+	switch config.AttachmentStorage {
+	case "database":
+	case "directory":
+		if err := os.MkdirAll(config.AttachmentDir, 0o750); err != nil {
+			log.Fatalf("failed to create attachment dir: %v\n", err)
+		}
+	default:
+		log.Fatalf("attachment storage must be \"database\" or \"directory\", got %q\n", config.AttachmentStorage)
 	}
 
 	if config.CookieSecret == "" {
@@ -138,6 +360,7 @@ func main() {
 	}
 
 	sessionStore = sessions.NewCookieStore([]byte(cookieSecret))
+	formTokenKey = cookieSecret
 
 	for _, v := range config.ValidMailRegexp {
 		r, err := regexp.Compile(v)
@@ -176,15 +399,61 @@ func main() {
 	}
 	config.FooterText = buf.String()
 
+	for name, translations := range map[string]map[string]string{
+		"info_text_translations":   config.InfoTextTranslations,
+		"footer_text_translations": config.FooterTextTranslations,
+	} {
+		for lang, text := range translations {
+			if !isLanguage(lang) {
+				log.Fatalf("got unsupported language %q in `%s`\n", lang, name)
+			}
+
+			buf.Reset()
+
+			if err := goldmark.Convert([]byte(text), &buf); err != nil {
+				log.Fatalf("failed to convert `%s` markdown for %q: %v\n", name, lang, err)
+			}
+			translations[lang] = buf.String()
+		}
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/", handlerIndex).Methods("GET")
 	r.HandleFunc("/new", handlerNew).Methods("GET", "POST")
+	r.HandleFunc("/lang/{lang}", handlerLanguage).Methods("GET")
+	r.HandleFunc("/preview", handlerMarkdownPreview).Methods("POST")
+	r.HandleFunc("/contact/{token}", tokenRateLimit(handlerContactConfirm)).Methods("GET")
 	r.HandleFunc("/feed", handlerRSSFeed).Methods("GET")
-	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}", handlerPosting).Methods("GET")
-	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/admin", handlerAdmin).Methods("GET", "POST")
-	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/preview", handlerPosting).Methods("GET")
-	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/verify", handlerVerify).Methods("GET")
-	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/delete", handlerDelete).Methods("POST")
+	r.HandleFunc("/feed.atom", handlerAtomFeed).Methods("GET")
+	r.HandleFunc("/feed.json", handlerJSONFeed).Methods("GET")
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(apiCORS)
+	api.HandleFunc("/postings", handlerAPIPostings).Methods("GET", "OPTIONS")
+	api.HandleFunc("/postings/{uuid:[0-9A-Fa-f-]{36}}", handlerAPIPosting).Methods("GET", "OPTIONS")
+	api.HandleFunc("/postings", apiKeyOnly(handlerAPICreatePosting)).Methods("POST")
+	api.HandleFunc("/postings/{uuid:[0-9A-Fa-f-]{36}}", apiKeyOnly(handlerAPIUpdatePosting)).Methods("PUT")
+	api.HandleFunc("/postings/{uuid:[0-9A-Fa-f-]{36}}", apiKeyOnly(handlerAPIDeletePosting)).Methods("DELETE")
+	api.HandleFunc("/postings/{uuid:[0-9A-Fa-f-]{36}}/close", apiKeyOnly(handlerAPIClosePosting)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/facets", handlerAPIFacets).Methods("GET", "OPTIONS")
+	api.HandleFunc("/institutes", handlerAPIInstitutes).Methods("GET", "OPTIONS")
+	api.HandleFunc("/openapi.json", handlerAPIOpenAPI).Methods("GET", "OPTIONS")
+
+	r.HandleFunc("/admin", adminOnly(handlerAdminDashboard)).Methods("GET")
+	r.HandleFunc("/admin/login", handlerAdminLogin).Methods("GET", "POST")
+	r.HandleFunc("/admin/logout", handlerAdminLogout).Methods("POST")
+	r.HandleFunc("/admin/outbox", adminOnly(handlerAdminOutbox)).Methods("GET")
+	r.HandleFunc("/admin/outbox/{id:[0-9]+}/{action:resend|discard}", adminOnly(handlerAdminOutboxAction)).Methods("POST")
+	r.HandleFunc("/admin/{uuid:[0-9A-Fa-f-]{36}}/{action:preview|edit}", adminOnly(handlerAdminPosting)).Methods("GET")
+	r.HandleFunc("/admin/{uuid:[0-9A-Fa-f-]{36}}/{action}", adminOnly(handlerAdminAction)).Methods("POST")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}", handlerPosting).Methods("GET", "POST")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/attachment/{id:[0-9A-Fa-f-]{36}}", handlerAttachment).Methods("GET")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/attachment/{id:[0-9A-Fa-f-]{36}}", tokenRateLimit(handlerAttachment)).Methods("GET")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/admin", tokenRateLimit(handlerAdmin)).Methods("GET", "POST")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/preview", tokenRateLimit(handlerPosting)).Methods("GET")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/verify", tokenRateLimit(handlerVerify)).Methods("GET")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/reject", tokenRateLimit(handlerReject)).Methods("GET", "POST")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/delete", tokenRateLimit(handlerDelete)).Methods("POST")
+	r.HandleFunc("/{uuid:[0-9A-Fa-f-]{36}}/{token}/extend", tokenRateLimit(handlerExtend)).Methods("GET")
 
 	srv := &http.Server{
 		Addr:         config.Addr,
@@ -204,6 +473,8 @@ func main() {
 		}
 	}()
 
+	go runOutbox(done)
+
 	janitorTicker := time.NewTicker(time.Second * time.Duration(config.JanitorInterval))
 
 	go func() {
@@ -211,6 +482,11 @@ func main() {
 			select {
 			case <-janitorTicker.C:
 				janitorReverify()
+				janitorExpiry()
+				janitorCleanup()
+				mailRateLimiter.prune()
+				tokenRateLimiter.prune()
+				pruneFormTokens()
 			case <-done:
 				log.Printf("janitor stopping\n")
 				return
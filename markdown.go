@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// postingMarkdown renders the descriptions of postings. Unlike the
+// `info_text`, the descriptions are written by anyone: raw HTML is
+// dropped (goldmark omits it unless `html.WithUnsafe` is set) and
+// `markdownSanitizer` restricts links and headings. Line breaks are kept
+// as in the plain text descriptions of older postings.
+var postingMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(markdownSanitizer{}, 1000)),
+	),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// The URL schemes links in postings may have
+var markdownLinkSchemes = []string{"http", "https", "mailto"}
+
+// markdownSanitizer removes links with other than the allowed schemes
+// and images, keeping their text, marks all links `nofollow` and demotes
+// headings below the posting title
+type markdownSanitizer struct{}
+
+func (markdownSanitizer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var unwrap, remove []ast.Node
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Heading:
+			n.Level = min(n.Level+2, 6)
+		case *ast.Link:
+			if !isSafeLink(string(n.Destination)) {
+				unwrap = append(unwrap, n)
+				break
+			}
+			n.SetAttributeString("rel", []byte("nofollow noopener"))
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && !isSafeLink(string(n.URL(source))) {
+				remove = append(remove, n)
+				break
+			}
+			n.SetAttributeString("rel", []byte("nofollow noopener"))
+		case *ast.Image:
+			// External images would be loaded by every visitor
+			unwrap = append(unwrap, n)
+		case *ast.RawHTML, *ast.HTMLBlock:
+			remove = append(remove, n)
+			return ast.WalkSkipChildren, nil
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range unwrap {
+		parent := n.Parent()
+		for c := n.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			parent.InsertBefore(parent, n, c)
+			c = next
+		}
+		parent.RemoveChild(parent, n)
+	}
+
+	for _, n := range remove {
+		parent := n.Parent()
+		if l, ok := n.(*ast.AutoLink); ok {
+			// Keep the text of the link
+			parent.InsertBefore(parent, n, ast.NewString(l.Label(source)))
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
+
+func isSafeLink(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	for _, scheme := range markdownLinkSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markdown renders the Markdown of a posting description as HTML
+func markdown(s string) template.HTML {
+	var buf bytes.Buffer
+	if err := postingMarkdown.Convert([]byte(s), &buf); err != nil {
+		// Rendering into a buffer does not fail, but never show
+		// the text unescaped
+		log.Printf("error rendering markdown: %v\n", err)
+		return replaceNewline(s)
+	}
+	return template.HTML(buf.String())
+}
+
+// plainText returns the text of a posting description without the
+// Markdown syntax on a single line, e.g. for excerpts
+func plainText(s string) string {
+	source := []byte(s)
+	doc := postingMarkdown.Parser().Parse(text.NewReader(source))
+
+	var b strings.Builder
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.AutoLink:
+			b.Write(n.Label(source))
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				b.Write(line.Value(source))
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// handlerMarkdownPreview renders the Markdown in the form value `text`
+// for the live preview of the posting form
+func handlerMarkdownPreview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, string(markdown(r.PostFormValue("text"))))
+}
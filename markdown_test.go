@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "link",
+			s:    "[Website](https://example.com)",
+			want: []string{`<a href="https://example.com" rel="nofollow noopener">Website</a>`},
+		},
+		{
+			name: "mailto link",
+			s:    "[Mail](mailto:a@example.com)",
+			want: []string{`<a href="mailto:a@example.com" rel="nofollow noopener">Mail</a>`},
+		},
+		{
+			name:    "javascript link",
+			s:       "[klick](javascript:alert(1))",
+			want:    []string{"klick"},
+			notWant: []string{"<a", "javascript"},
+		},
+		{
+			name:    "javascript link in upper case",
+			s:       "[klick](JavaScript:alert(1))",
+			want:    []string{"klick"},
+			notWant: []string{"<a", "alert"},
+		},
+		{
+			name:    "data link",
+			s:       "[klick](data:text/html;base64,PHNjcmlwdD4=)",
+			notWant: []string{"<a", "data:"},
+		},
+		{
+			name:    "javascript autolink",
+			s:       "<javascript:alert(1)>",
+			notWant: []string{"<a"},
+		},
+		{
+			name: "url",
+			s:    "Siehe https://example.com",
+			want: []string{`<a href="https://example.com" rel="nofollow noopener">https://example.com</a>`},
+		},
+		{
+			name:    "raw inline html",
+			s:       `Text <script>alert(1)</script> <img src=x onerror=alert(1)>`,
+			want:    []string{"Text"},
+			notWant: []string{"<script", "<img", "onerror"},
+		},
+		{
+			name:    "raw html block",
+			s:       "<div onclick=\"alert(1)\">\nText\n</div>",
+			notWant: []string{"<div", "onclick"},
+		},
+		{
+			name:    "image",
+			s:       "![Logo](https://example.com/logo.png)",
+			want:    []string{"Logo"},
+			notWant: []string{"<img", "logo.png"},
+		},
+		{
+			name:    "heading",
+			s:       "# Aufgaben",
+			want:    []string{"<h3>Aufgaben</h3>"},
+			notWant: []string{"<h1>"},
+		},
+		{
+			name: "line breaks",
+			s:    "Zeile 1\nZeile 2",
+			want: []string{"Zeile 1<br>\nZeile 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := string(markdown(tt.s))
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: markdown(%q) = %q, want it to contain %q", tt.name, tt.s, got, w)
+			}
+		}
+		for _, w := range tt.notWant {
+			if strings.Contains(got, w) {
+				t.Errorf("%s: markdown(%q) = %q, want it not to contain %q", tt.name, tt.s, got, w)
+			}
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"Einfacher Text", "Einfacher Text"},
+		{"## Titel\n\nEin **fetter** Text", "Titel Ein fetter Text"},
+		{"[Link](https://example.com) und `code`", "Link und code"},
+		{"- eins\n- zwei", "eins zwei"},
+		{"Text <b>fett</b>", "Text fett"},
+	}
+
+	for _, tt := range tests {
+		if got := plainText(tt.s); got != tt.want {
+			t.Errorf("plainText(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
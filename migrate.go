@@ -0,0 +1,256 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The migrations of the database schema, named `NNNN_description.sql`;
+// they are applied in order of their number, each in a transaction
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations returns the embedded migrations sorted by version;
+// versions must start at 1 without gaps
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+
+	for _, name := range names {
+		base := path.Base(name)
+
+		prefix, _, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name %q", base)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %q: %w", base, err)
+		}
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{Version: version, Name: base, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %q: expected version %d", m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// The tables and columns of postings added by the migrations up to
+// 0007, which databases created before migrations existed may already
+// have from their `init.sql`; see `legacySchemaVersion`
+var legacySchemaProbes = []struct {
+	Version int
+	Table   string
+	Column  string
+}{
+	{2, "postings_fts", ""},
+	{3, "postings", "reverify_requested_at"},
+	{4, "postings", "deleted_at"},
+	{5, "postings", "expires_on"},
+	{6, "postings", "rejected"},
+	{7, "api_keys", ""},
+}
+
+// schemaVersion returns the version of the database schema; databases
+// created before migrations existed have the postings table but no
+// `schema_version` table, their version is found by `legacySchemaVersion`
+func schemaVersion() (int, error) {
+	var n int
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&n); err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		var version int
+		if err := db.QueryRow("SELECT coalesce(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+			return 0, err
+		}
+		return version, nil
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'postings'").Scan(&n); err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		return legacySchemaVersion()
+	}
+
+	return 0, nil
+}
+
+// legacySchemaVersion returns the version of a database created before
+// migrations existed: the baseline schema plus the changes of the
+// migrations its `init.sql` already made, which were added in order
+func legacySchemaVersion() (int, error) {
+	version := 1
+
+	for _, p := range legacySchemaProbes {
+		var (
+			n   int
+			err error
+		)
+
+		if p.Column == "" {
+			err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", p.Table).Scan(&n)
+		} else {
+			err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", p.Table, p.Column).Scan(&n)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if n == 0 {
+			break
+		}
+		version = p.Version
+	}
+
+	return version, nil
+}
+
+// migrate applies all migrations newer than the schema of the database
+func migrate(migrations []migration) error {
+	version, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER NOT NULL PRIMARY KEY,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return err
+	}
+
+	// Record the schema of databases created before migrations existed,
+	// the migrations it already has are skipped
+	for v := 1; v <= version; v++ {
+		if _, err := db.Exec("INSERT OR IGNORE INTO schema_version (version) VALUES (?)", v); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		if err := applyMigration(m); err != nil {
+			return fmt.Errorf("migration %q: %w", m.Name, err)
+		}
+
+		log.Printf("applied migration %q\n", m.Name)
+	}
+
+	return nil
+}
+
+func applyMigration(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES (?)", m.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkSchema makes sure the database schema is the one the binary
+// knows, upgrading it if `auto_migrate` is set
+func checkSchema() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+
+	version, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version == 0:
+		return fmt.Errorf("database at %q is not initialized, run with -init-db", config.DBPath)
+	case version > latest:
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d, refusing to start an older binary", version, latest)
+	case version < latest && !config.AutoMigrate:
+		return fmt.Errorf("database schema version %d is older than %d, run with -migrate", version, latest)
+	case version < latest:
+		log.Printf("upgrading database schema from version %d to %d\n", version, latest)
+		return migrate(migrations)
+	}
+
+	return nil
+}
+
+// runMigrations applies all pending migrations, e.g. for `-init-db` and
+// `-migrate`
+func runMigrations() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	if err := migrate(migrations); err != nil {
+		return err
+	}
+
+	log.Printf("database schema at version %d\n", len(migrations))
+
+	return nil
+}
@@ -0,0 +1,31 @@
+CREATE VIRTUAL TABLE postings_fts USING fts5(
+	title,
+	text,
+	institute,
+	advisor,
+	supervisor,
+	audience,
+	content = 'postings',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER postings_fts_insert AFTER INSERT ON postings BEGIN
+	INSERT INTO postings_fts (rowid, title, text, institute, advisor, supervisor, audience)
+	VALUES (new.id, new.title, new.text, new.institute, new.advisor, new.supervisor, new.audience);
+END;
+
+CREATE TRIGGER postings_fts_delete AFTER DELETE ON postings BEGIN
+	INSERT INTO postings_fts (postings_fts, rowid, title, text, institute, advisor, supervisor, audience)
+	VALUES ('delete', old.id, old.title, old.text, old.institute, old.advisor, old.supervisor, old.audience);
+END;
+
+CREATE TRIGGER postings_fts_update AFTER UPDATE OF title, text, institute, advisor, supervisor, audience ON postings BEGIN
+	INSERT INTO postings_fts (postings_fts, rowid, title, text, institute, advisor, supervisor, audience)
+	VALUES ('delete', old.id, old.title, old.text, old.institute, old.advisor, old.supervisor, old.audience);
+	INSERT INTO postings_fts (rowid, title, text, institute, advisor, supervisor, audience)
+	VALUES (new.id, new.title, new.text, new.institute, new.advisor, new.supervisor, new.audience);
+END;
+
+-- Index postings created before the search index existed
+INSERT INTO postings_fts (postings_fts) VALUES ('rebuild');
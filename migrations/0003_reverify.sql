@@ -0,0 +1 @@
+ALTER TABLE postings ADD COLUMN reverify_requested_at TIMESTAMP DEFAULT NULL;
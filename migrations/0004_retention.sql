@@ -0,0 +1,4 @@
+ALTER TABLE postings ADD COLUMN deleted_at TIMESTAMP DEFAULT NULL;
+
+-- Deleted postings so far were last touched by their deletion
+UPDATE postings SET deleted_at = last_updated_at WHERE deleted = 1;
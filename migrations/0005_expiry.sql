@@ -0,0 +1,2 @@
+ALTER TABLE postings ADD COLUMN expires_on TEXT DEFAULT NULL;
+ALTER TABLE postings ADD COLUMN expiry_reminded INTEGER DEFAULT 0;
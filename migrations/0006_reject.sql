@@ -0,0 +1,3 @@
+ALTER TABLE postings ADD COLUMN rejected INTEGER DEFAULT 0;
+ALTER TABLE postings ADD COLUMN rejected_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE postings ADD COLUMN reject_reason TEXT DEFAULT "";
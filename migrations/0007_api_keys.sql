@@ -0,0 +1,13 @@
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP DEFAULT NULL,
+	revoked INTEGER DEFAULT 0,
+
+	key_hash TEXT NOT NULL UNIQUE,
+	email_regexp TEXT NOT NULL
+);
+
+ALTER TABLE postings ADD COLUMN api_key_id INTEGER DEFAULT NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	state TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER DEFAULT 0,
+	next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_error TEXT DEFAULT "",
+	sent_at TIMESTAMP DEFAULT NULL,
+
+	template TEXT NOT NULL,
+	recipients TEXT NOT NULL,
+	subject TEXT DEFAULT "",
+	message BLOB NOT NULL
+);
+
+CREATE INDEX outbox_state ON outbox (state, next_attempt_at);
@@ -0,0 +1,2 @@
+-- The language of the posting's author, for the mails to the author
+ALTER TABLE postings ADD COLUMN language TEXT NOT NULL DEFAULT 'de';
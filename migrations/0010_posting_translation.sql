@@ -0,0 +1,43 @@
+ALTER TABLE postings ADD COLUMN translation_language TEXT NOT NULL DEFAULT '';
+ALTER TABLE postings ADD COLUMN translation_title TEXT NOT NULL DEFAULT '';
+ALTER TABLE postings ADD COLUMN translation_text TEXT NOT NULL DEFAULT '';
+
+-- Search the translations as well; the columns of an FTS5 table cannot be
+-- altered, so the index is created again
+DROP TRIGGER postings_fts_insert;
+DROP TRIGGER postings_fts_delete;
+DROP TRIGGER postings_fts_update;
+DROP TABLE postings_fts;
+
+CREATE VIRTUAL TABLE postings_fts USING fts5(
+	title,
+	text,
+	institute,
+	advisor,
+	supervisor,
+	audience,
+	translation_title,
+	translation_text,
+	content = 'postings',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER postings_fts_insert AFTER INSERT ON postings BEGIN
+	INSERT INTO postings_fts (rowid, title, text, institute, advisor, supervisor, audience, translation_title, translation_text)
+	VALUES (new.id, new.title, new.text, new.institute, new.advisor, new.supervisor, new.audience, new.translation_title, new.translation_text);
+END;
+
+CREATE TRIGGER postings_fts_delete AFTER DELETE ON postings BEGIN
+	INSERT INTO postings_fts (postings_fts, rowid, title, text, institute, advisor, supervisor, audience, translation_title, translation_text)
+	VALUES ('delete', old.id, old.title, old.text, old.institute, old.advisor, old.supervisor, old.audience, old.translation_title, old.translation_text);
+END;
+
+CREATE TRIGGER postings_fts_update AFTER UPDATE OF title, text, institute, advisor, supervisor, audience, translation_title, translation_text ON postings BEGIN
+	INSERT INTO postings_fts (postings_fts, rowid, title, text, institute, advisor, supervisor, audience, translation_title, translation_text)
+	VALUES ('delete', old.id, old.title, old.text, old.institute, old.advisor, old.supervisor, old.audience, old.translation_title, old.translation_text);
+	INSERT INTO postings_fts (rowid, title, text, institute, advisor, supervisor, audience, translation_title, translation_text)
+	VALUES (new.id, new.title, new.text, new.institute, new.advisor, new.supervisor, new.audience, new.translation_title, new.translation_text);
+END;
+
+INSERT INTO postings_fts (postings_fts) VALUES ('rebuild');
@@ -0,0 +1,23 @@
+CREATE TABLE attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	uuid TEXT NOT NULL UNIQUE,
+	posting_id INTEGER NOT NULL REFERENCES postings (id),
+
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+
+	-- The content if stored in the database, NULL if stored as file in
+	-- `attachment_dir`
+	data BLOB DEFAULT NULL
+);
+
+CREATE INDEX attachments_posting_id ON attachments (posting_id);
+
+-- Attachments go together with their posting; files in `attachment_dir`
+-- without attachment are removed by the janitor
+CREATE TRIGGER attachments_posting_delete AFTER DELETE ON postings BEGIN
+	DELETE FROM attachments WHERE posting_id = old.id;
+END;
@@ -0,0 +1,21 @@
+CREATE TABLE contact_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	posting_id INTEGER NOT NULL REFERENCES postings (id),
+
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	confirm_token TEXT NOT NULL UNIQUE,
+	confirmed_at TIMESTAMP DEFAULT NULL,
+
+	-- The language of the sender, for the confirmation mail
+	language TEXT NOT NULL,
+
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	message TEXT NOT NULL
+);
+
+CREATE INDEX contact_messages_email ON contact_messages (email, created_at);
+
+CREATE TRIGGER contact_messages_posting_delete AFTER DELETE ON postings BEGIN
+	DELETE FROM contact_messages WHERE posting_id = old.id;
+END;
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The states of a mail in the outbox; mails which could not be sent
+// after `mail_max_attempts` attempts are dead and only sent again on
+// request
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+)
+
+const (
+	// How often the outbox is checked for mails due for another attempt
+	outboxInterval = 30 * time.Second
+
+	// Delay before the second attempt, doubled on every further attempt
+	// up to outboxMaxBackoff
+	outboxBackoff    = time.Minute
+	outboxMaxBackoff = 6 * time.Hour
+
+	// Sent mails are kept for inspection and then removed by the janitor;
+	// dead mails, which hold the recipients' addresses as well, are kept
+	// longer to be sent again on request
+	outboxKeepSentDays = 7
+	outboxKeepDeadDays = 30
+)
+
+// outboxWake wakes the mail sender after mails were queued
+var outboxWake = make(chan struct{}, 1)
+
+// execer is implemented by both *sql.DB and *sql.Tx, so mails can be
+// queued in the transaction of the change they are about
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// outboxMail is a mail in the outbox
+type outboxMail struct {
+	ID            int64
+	CreatedAt     time.Time
+	State         string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	Template      string
+	Recipients    []string
+	Subject       string
+}
+
+// queueMail composes a mail from the template `name` in lang and stores
+// it in the outbox; the mail is sent by `runOutbox` once the transaction
+// is committed, call `wakeOutbox` to send it right away
+func queueMail(ex execer, to []string, lang, name string, data any) error {
+	msg, subject, err := composeMail(to, lang, name, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.Exec(`
+INSERT INTO outbox (template, recipients, subject, message)
+VALUES (?, ?, ?, ?)`,
+		name, strings.Join(to, "\n"), subject, msg)
+	return err
+}
+
+// wakeOutbox makes the mail sender check the outbox now
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox sends the queued mails until done is closed
+func runOutbox(done <-chan struct{}) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		sendOutbox()
+
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		case <-done:
+			log.Printf("mail sender stopping\n")
+			return
+		}
+	}
+}
+
+// sendOutbox attempts to send all mails which are due
+func sendOutbox() {
+	type outboxMessage struct {
+		id         int64
+		recipients string
+		message    []byte
+		attempts   int
+	}
+
+	var messages []outboxMessage
+
+	rows, err := db.Query(`
+SELECT id, recipients, message, attempts
+FROM outbox
+WHERE state = ?
+    AND next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY id`,
+		outboxPending)
+	if err != nil {
+		log.Printf("outbox: error reading mails: %v\n", err)
+		return
+	}
+
+	for rows.Next() {
+		var m outboxMessage
+		if err := rows.Scan(&m.id, &m.recipients, &m.message, &m.attempts); err != nil {
+			rows.Close()
+			log.Printf("outbox: error scanning mail: %v\n", err)
+			return
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+
+	for _, m := range messages {
+		attempts := m.attempts + 1
+
+		sendErr := deliverMail(strings.Split(m.recipients, "\n"), m.message)
+		if sendErr == nil {
+			if _, err := db.Exec("UPDATE outbox SET state = ?, attempts = ?, last_error = '', sent_at = CURRENT_TIMESTAMP WHERE id = ?",
+				outboxSent, attempts, m.id); err != nil {
+				log.Printf("outbox: error marking mail %d as sent: %v\n", m.id, err)
+			}
+			continue
+		}
+
+		state := outboxPending
+		if attempts >= config.MailMaxAttempts {
+			state = outboxDead
+			log.Printf("outbox: giving up on mail %d after %d attempts: %v\n", m.id, attempts, sendErr)
+		} else {
+			log.Printf("outbox: error sending mail %d (attempt %d): %v\n", m.id, attempts, sendErr)
+		}
+
+		if _, err := db.Exec(`
+UPDATE outbox
+SET
+    state = ?,
+    attempts = ?,
+    last_error = ?,
+    next_attempt_at = datetime('now', ?)
+WHERE id = ?`,
+			state, attempts, sendErr.Error(), fmt.Sprintf("+%d seconds", int(backoff(attempts).Seconds())), m.id); err != nil {
+			log.Printf("outbox: error updating mail %d: %v\n", m.id, err)
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt after the given
+// number of failed attempts
+func backoff(attempts int) time.Duration {
+	d := outboxBackoff
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+// listOutbox returns the mails not sent yet, the dead ones first
+func listOutbox() ([]outboxMail, error) {
+	rows, err := db.Query(`
+SELECT id, created_at, state, attempts, next_attempt_at, last_error, template, recipients, subject
+FROM outbox
+WHERE state != ?
+ORDER BY state = ?, id`,
+		outboxSent, outboxPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []outboxMail
+
+	for rows.Next() {
+		var (
+			m          outboxMail
+			recipients string
+		)
+		if err := rows.Scan(&m.ID, &m.CreatedAt, &m.State, &m.Attempts, &m.NextAttemptAt, &m.LastError,
+			&m.Template, &recipients, &m.Subject); err != nil {
+			return nil, err
+		}
+		m.Recipients = strings.Split(recipients, "\n")
+		mails = append(mails, m)
+	}
+
+	return mails, rows.Err()
+}
+
+// resendMail queues a mail which is not sent yet for an immediate
+// attempt, resetting its attempts
+func resendMail(id int64) error {
+	result, err := db.Exec(`
+UPDATE outbox
+SET
+    state = ?,
+    attempts = 0,
+    next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = ?
+    AND state != ?`,
+		outboxPending, id, outboxSent)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	wakeOutbox()
+
+	return nil
+}
+
+// discardMail removes a dead mail from the outbox
+func discardMail(id int64) error {
+	result, err := db.Exec("DELETE FROM outbox WHERE id = ? AND state = ?", id, outboxDead)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// printOutbox writes a table of the mails not sent yet to stdout
+func printOutbox() error {
+	mails, err := listOutbox()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tCREATED\tSTATE\tATTEMPTS\tNEXT ATTEMPT\tTO\tSUBJECT\tLAST ERROR\n")
+
+	for _, m := range mails {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", m.ID, m.CreatedAt.Format(time.DateTime), m.State, m.Attempts,
+			m.NextAttemptAt.Format(time.DateTime), strings.Join(m.Recipients, ", "), m.Subject, m.LastError)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,111 @@
+package main
+
+// insertPosting stores a new posting; apiKeyID is the id of the API key
+// the posting was created with or 0 for postings created with the form
+func insertPosting(ex execer, p *Posting, adminToken, verifyToken string, apiKeyID int64) error {
+	_, err := ex.Exec(`
+INSERT INTO postings (
+    uuid,
+    email,
+    admin_token,
+    verify_token,
+    title,
+    institute,
+    advisor,
+    supervisor,
+    audience,
+    category,
+    type,
+    degree,
+    start,
+    required_months,
+    required_effort,
+    text,
+    expires_on,
+    verified,
+    last_verified_at,
+    api_key_id,
+    language,
+    translation_language,
+    translation_title,
+    translation_text
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, nullif(?, ''), ?,
+    CASE WHEN ? THEN CURRENT_TIMESTAMP END, nullif(?, 0), coalesce(nullif(?, ''), ?), ?, ?, ?)`,
+		p.UUID, p.Email, adminToken, verifyToken, p.Title, p.Institute,
+		p.Advisor, p.Supervisor, p.Audience, p.Category, p.Type,
+		p.Degree, p.Start, p.RequiredMonths, p.RequiredEffort, p.Text,
+		p.ExpiresOn, p.Verified, p.Verified, apiKeyID, p.Language, defaultLanguage,
+		p.Translation.Language, p.Translation.Title, p.Translation.Text)
+	return err
+}
+
+// updatePosting stores the editable fields of an existing posting; the
+// expiry reminder is sent again if the expiry date changed
+func updatePosting(ex execer, p *Posting) error {
+	_, err := ex.Exec(`
+UPDATE postings
+SET
+    title = ?,
+    institute = ?,
+    advisor = ?,
+    supervisor = ?,
+    audience = ?,
+    category = ?,
+    type = ?,
+    degree = ?,
+    start = ?,
+    required_months = ?,
+    required_effort = ?,
+    text = ?,
+    expiry_reminded = CASE WHEN coalesce(expires_on, '') = ? THEN expiry_reminded ELSE 0 END,
+    expires_on = nullif(?, ''),
+    language = coalesce(nullif(?, ''), language),
+    translation_language = ?,
+    translation_title = ?,
+    translation_text = ?,
+    last_updated_at = CURRENT_TIMESTAMP
+WHERE uuid = ?`,
+		p.Title, p.Institute, p.Advisor, p.Supervisor, p.Audience,
+		p.Category, p.Type, p.Degree, p.Start, p.RequiredMonths,
+		p.RequiredEffort, p.Text, p.ExpiresOn, p.ExpiresOn, p.Language,
+		p.Translation.Language, p.Translation.Title, p.Translation.Text, p.UUID)
+	return err
+}
+
+// state returns the name of the moderation state of the posting, see
+// `postingStates`
+func (p Posting) state() string {
+	switch {
+	case p.Deleted:
+		return "deleted"
+	case p.Rejected:
+		return "rejected"
+	case p.Expired:
+		return "expired"
+	case !p.Verified:
+		return "unverified"
+	}
+	return statePublished
+}
+
+// localized returns the posting with the title and text of its
+// translation if it was translated into lang; the original title and
+// text become the translation
+func (p Posting) localized(lang string) Posting {
+	if p.Translation.Language == "" || p.Translation.Language != lang || p.Language == lang {
+		return p
+	}
+
+	p.Language, p.Translation.Language = p.Translation.Language, p.Language
+	p.Title, p.Translation.Title = p.Translation.Title, p.Title
+	p.Text, p.Translation.Text = p.Translation.Text, p.Text
+
+	return p
+}
+
+// ContactURL returns the link to the contact form of the posting, which
+// relays messages to the author without revealing their address
+func (p Posting) ContactURL() string {
+	return config.URL + "/" + p.UUID + "#contact"
+}
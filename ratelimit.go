@@ -0,0 +1,294 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter counts events, e.g. submitted forms, per key like the
+// client IP address within a sliding window; the counts are only kept in
+// memory and start over on restart
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+var (
+	// Forms which send mails, i.e. new postings and contact messages,
+	// per client IP address, see `rate_limit_mail_per_ip`
+	mailRateLimiter *rateLimiter
+
+	// Requests with an invalid token or admin password per client IP
+	// address, see `rate_limit_token_failures`
+	tokenRateLimiter *rateLimiter
+
+	// Proxies whose X-Forwarded-For header is used for the client IP
+	// address, see `trusted_proxies`
+	trustedProxies []netip.Prefix
+)
+
+// newRateLimiter returns a limiter allowing limit events per window; a
+// limit of 0 allows any number
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// exceeded reports whether the limit for key is reached and how long
+// until the next event is allowed
+func (l *rateLimiter) exceeded(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return false, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := l.recent(key, time.Now())
+	if len(events) < l.limit {
+		return false, 0
+	}
+
+	return true, time.Until(events[len(events)-l.limit].Add(l.window))
+}
+
+// add counts an event for key
+func (l *rateLimiter) add(key string) {
+	if l.limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.events[key] = append(l.recent(key, now), now)
+}
+
+// recent returns the events of key within the window and drops the
+// older ones; the caller must hold l.mu
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	events := l.events[key]
+
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= l.window {
+		i++
+	}
+
+	if i == len(events) {
+		delete(l.events, key)
+		return nil
+	}
+
+	events = events[i:]
+	l.events[key] = events
+
+	return events
+}
+
+// prune drops the keys without events within the window, run by the
+// janitor to free the memory of clients which did not come back
+func (l *rateLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key := range l.events {
+		l.recent(key, now)
+	}
+}
+
+// emailRateLimitExceeded reports whether the email address created
+// `rate_limit_new_per_email` postings within the last day and how long
+// until it may create the next
+func emailRateLimitExceeded(email string) (bool, time.Duration, error) {
+	if config.RateLimitNewPerEmail <= 0 {
+		return false, 0, nil
+	}
+
+	var (
+		n      int
+		oldest time.Time
+	)
+
+	rows, err := db.Query(`
+SELECT created_at
+FROM postings
+WHERE email = ? COLLATE NOCASE
+    AND created_at > datetime('now', '-1 day')
+ORDER BY created_at DESC
+LIMIT ?`,
+		email, config.RateLimitNewPerEmail)
+	if err != nil {
+		return false, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(&oldest); err != nil {
+			return false, 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return false, 0, err
+	}
+
+	if n < config.RateLimitNewPerEmail {
+		return false, 0, nil
+	}
+
+	return true, time.Until(oldest.Add(24 * time.Hour)), nil
+}
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of
+// `trusted_proxies`
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client; behind trusted proxies
+// it is the last address in X-Forwarded-For which is not a trusted proxy
+// itself, as clients can send the header with any addresses
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+
+	if !isTrustedProxy(addr) {
+		return addr.Unmap().String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = a
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+
+	return addr.Unmap().String()
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// tokenRateLimit wraps handlers of links with tokens; requests answered
+// with 403 or 404 count as failed guess, clients with too many failures
+// get 429 until the window passed
+func tokenRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+
+		if exceeded, retryAfter := tokenRateLimiter.exceeded(ip); exceeded {
+			log.Printf("too many failed token attempts from %q for %q\n", ip, r.URL.Path)
+			handler429(w, r, retryAfter)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+
+		h(rec, r)
+
+		if rec.status == http.StatusForbidden || rec.status == http.StatusNotFound {
+			tokenRateLimiter.add(ip)
+		}
+	}
+}
+
+func handler429(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	lang := requestLang(r)
+
+	minutes := int(math.Ceil(retryAfter.Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	tmplData := TemplateDataError{
+		TemplateDataPage: TemplateDataPage{
+			TitleText:  config.TitleText,
+			FooterText: footerText(lang),
+			Version:    Version,
+			Lang:       lang,
+			Languages:  languageLinks(r, lang),
+		},
+		RetryMinutes: minutes,
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := tmpl[tmplData.Lang].ExecuteTemplate(w, "error429", tmplData); err != nil {
+		log.Printf("error executing template: %v\n", err)
+	}
+}
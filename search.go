@@ -0,0 +1,181 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+// Markers used by the FTS5 `highlight()` function and `searchExcerpt` to
+// enclose matched terms; replaced by `<mark>` tags after escaping
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// ftsQuery turns free text user input into a FTS5 query expression.
+// Every term is quoted (so FTS5 operators and syntax in the input have no
+// effect) and matched as a prefix; all terms must match. An empty string
+// is returned if the input contains no searchable terms.
+func ftsQuery(q string) string {
+	var terms []string
+
+	for _, t := range strings.Fields(q) {
+		if strings.IndexFunc(t, func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		}) < 0 {
+			// Term without any letters or digits; the tokenizer
+			// would drop it anyway
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(t, `"`, `""`)+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// highlight escapes s and replaces the FTS5 match markers with `<mark>`
+// tags
+func highlight(s string) template.HTML {
+	var (
+		b    strings.Builder
+		open bool
+	)
+
+	for _, part := range strings.SplitAfter(template.HTMLEscapeString(s), highlightEnd) {
+		before, after, found := strings.Cut(strings.TrimSuffix(part, highlightEnd), highlightStart)
+		b.WriteString(strings.ReplaceAll(before, highlightStart, ""))
+		if found {
+			b.WriteString("<mark>")
+			b.WriteString(strings.ReplaceAll(after, highlightStart, ""))
+			open = true
+		}
+		if open && strings.HasSuffix(part, highlightEnd) {
+			b.WriteString("</mark>")
+			open = false
+		}
+	}
+
+	if open {
+		b.WriteString("</mark>")
+	}
+
+	return template.HTML(b.String())
+}
+
+// searchExcerptWords is the number of words in the excerpts of search
+// results
+const searchExcerptWords = 32
+
+// foldDiacritics replaces the Latin letters with diacritics by their base
+// letters, like the `remove_diacritics` option of the search index
+var foldDiacritics = func() *strings.Replacer {
+	from := []rune("àáâãäåçèéêëìíîïñòóôõöøùúûüýÿāăąćĉċčďđēĕėęěĝğġģĥħĩīĭįĵķĺļľłńņňōŏőŕŗřśŝşšţťũūŭůűųŵŷźżž")
+	to := "aaaaaaceeeeiiiinoooooouuuuyyaaaccccddeeeeegggghhiiiijkllllnnnooorrrssssttuuuuuuwyzzz"
+
+	var oldnew []string
+	for i, r := range from {
+		oldnew = append(oldnew, string(r), to[i:i+1])
+	}
+	return strings.NewReplacer(oldnew...)
+}()
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func foldTerm(s string) string {
+	return foldDiacritics.Replace(strings.ToLower(s))
+}
+
+// searchTerms returns the words of the search input q as the search
+// index matches them, lower case and without diacritics
+func searchTerms(q string) []string {
+	var terms []string
+	for _, t := range strings.FieldsFunc(q, func(r rune) bool { return !isWordRune(r) }) {
+		terms = append(terms, foldTerm(t))
+	}
+	return terms
+}
+
+// searchExcerpt returns about `searchExcerptWords` words of the plain
+// text s around the first word matching the search input q, with the
+// matching words enclosed in the markers replaced by `highlight`; the
+// FTS5 `snippet()` would show the Markdown syntax of the indexed text
+func searchExcerpt(s, q string) string {
+	terms := searchTerms(q)
+
+	type word struct {
+		start, end int
+		match      bool
+	}
+
+	var (
+		words []word
+		first = -1
+	)
+
+	for i := 0; i < len(s); {
+		start := strings.IndexFunc(s[i:], isWordRune)
+		if start < 0 {
+			break
+		}
+		start += i
+
+		end := strings.IndexFunc(s[start:], func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += start
+		}
+
+		w := word{start: start, end: end}
+		folded := foldTerm(s[start:end])
+		for _, t := range terms {
+			if strings.HasPrefix(folded, t) {
+				w.match = true
+				break
+			}
+		}
+		if w.match && first < 0 {
+			first = len(words)
+		}
+
+		words = append(words, w)
+		i = end
+	}
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	// Show some words before the first match, unless the excerpt would
+	// end before the text does anyway
+	from := max(0, min(first-searchExcerptWords/4, len(words)-searchExcerptWords))
+	to := min(len(words), from+searchExcerptWords)
+
+	var b strings.Builder
+
+	if from > 0 {
+		b.WriteString("…")
+	}
+
+	pos := words[from].start
+	for _, w := range words[from:to] {
+		b.WriteString(s[pos:w.start])
+		if w.match {
+			b.WriteString(highlightStart + s[w.start:w.end] + highlightEnd)
+		} else {
+			b.WriteString(s[w.start:w.end])
+		}
+		pos = w.end
+	}
+
+	if to < len(words) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(strings.TrimSpace(s[pos:]))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"Robotik", `"Robotik"*`},
+		{"maschinelles  Lernen", `"maschinelles"* "Lernen"*`},
+		{`say "hi"`, `"say"* """hi"""*`},
+		{"a OR b", `"a"* "OR"* "b"*`},
+		{"NEAR(a b)", `"NEAR(a"* "b)"*`},
+		{"- * ( )", ""},
+		{"C++ -", `"C++"*`},
+	}
+
+	for _, tt := range tests {
+		if got := ftsQuery(tt.q); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"a \x02match\x03 b", "a <mark>match</mark> b"},
+		{"\x02one\x03 and \x02two\x03", "<mark>one</mark> and <mark>two</mark>"},
+		{"<b>\x02x\x03</b>", "&lt;b&gt;<mark>x</mark>&lt;/b&gt;"},
+		{"unclosed \x02mark", "unclosed <mark>mark</mark>"},
+		{"stray\x03 end", "stray end"},
+		{"\x02a\x02b\x03", "<mark>ab</mark>"},
+	}
+
+	for _, tt := range tests {
+		if got := string(highlight(tt.s)); got != tt.want {
+			t.Errorf("highlight(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSearchExcerpt(t *testing.T) {
+	tests := []struct {
+		s    string
+		q    string
+		want string
+	}{
+		{"", "x", ""},
+		{"Ein Text ohne Treffer.", "robotik", "Ein Text ohne Treffer."},
+		{"Analyse von Daten.", "daten", "Analyse von \x02Daten\x03."},
+		{"Arbeit bei Müller", "muller", "Arbeit bei \x02Müller\x03"},
+		{"Robotik und Roboter", "ROBO", "\x02Robotik\x03 und \x02Roboter\x03"},
+		{
+			"w1 w2 w3 w4 w5 w6 w7 w8 w9 w10 w11 w12 w13 w14 w15 w16 w17 w18 w19 w20 " +
+				"w21 w22 w23 w24 w25 w26 w27 w28 w29 w30 w31 w32 w33 w34 w35 w36 w37 w38 treffer",
+			"treffer",
+			"…w8 w9 w10 w11 w12 w13 w14 w15 w16 w17 w18 w19 w20 " +
+				"w21 w22 w23 w24 w25 w26 w27 w28 w29 w30 w31 w32 w33 w34 w35 w36 w37 w38 \x02treffer\x03",
+		},
+		{
+			"treffer w2 w3 w4 w5 w6 w7 w8 w9 w10 w11 w12 w13 w14 w15 w16 w17 w18 w19 w20 " +
+				"w21 w22 w23 w24 w25 w26 w27 w28 w29 w30 w31 w32 w33",
+			"treffer",
+			"\x02treffer\x03 w2 w3 w4 w5 w6 w7 w8 w9 w10 w11 w12 w13 w14 w15 w16 w17 w18 w19 w20 " +
+				"w21 w22 w23 w24 w25 w26 w27 w28 w29 w30 w31 w32…",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := searchExcerpt(tt.s, tt.q); got != tt.want {
+			t.Errorf("searchExcerpt(%q, %q) = %q, want %q", tt.s, tt.q, got, tt.want)
+		}
+	}
+}
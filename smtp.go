@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpTransport sends mails via an SMTP server; unlike `smtp.SendMail`
+// the connection security is configurable and the SMTP dialogue can be
+// traced, e.g. for `-test-mail`
+type smtpTransport struct {
+	Host string
+	Port string
+
+	// "none", "auto" (STARTTLS if offered), "starttls" or "tls" (implicit
+	// TLS, usually port 465)
+	Security  string
+	TLSConfig *tls.Config
+
+	// Auth is nil for relays accepting mails without authentication
+	Auth smtp.Auth
+
+	// Name sent with EHLO, "localhost" if empty
+	HELO string
+
+	// Timeout for connecting and for the whole SMTP session
+	Timeout time.Duration
+
+	// Trace receives the SMTP dialogue if set; credentials are masked
+	Trace io.Writer
+}
+
+// newSMTPTransport returns the SMTP transport for the `smtp_*` settings
+func newSMTPTransport(c Config) (smtpTransport, error) {
+	t := smtpTransport{
+		Host:     c.SMTPHost,
+		Port:     c.SMTPPort,
+		Security: c.SMTPSecurity,
+		HELO:     c.SMTPHELO,
+		Timeout:  time.Duration(c.SMTPTimeout) * time.Second,
+	}
+
+	switch t.Security {
+	case "none", "auto", "starttls", "tls":
+	default:
+		return t, fmt.Errorf("smtp security must be \"none\", \"auto\", \"starttls\" or \"tls\", got %q", t.Security)
+	}
+
+	if t.Timeout <= 0 {
+		return t, errors.New("smtp timeout must be at least 1 second")
+	}
+
+	t.TLSConfig = &tls.Config{ServerName: c.SMTPHost}
+
+	if c.SMTPCAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		data, err := os.ReadFile(c.SMTPCAFile)
+		if err != nil {
+			return t, fmt.Errorf("failed to read smtp CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return t, fmt.Errorf("no certificates found in smtp CA file %q", c.SMTPCAFile)
+		}
+
+		t.TLSConfig.RootCAs = pool
+	}
+
+	auth := c.SMTPAuth
+	if auth == "" {
+		// Authenticate only if credentials are given
+		auth = "none"
+		if c.SMTPUser != "" {
+			auth = "plain"
+		}
+	}
+
+	switch auth {
+	case "none":
+	case "plain":
+		t.Auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPass, c.SMTPHost)
+	case "login":
+		t.Auth = loginAuth{username: c.SMTPUser, password: c.SMTPPass}
+	case "cram-md5":
+		t.Auth = smtp.CRAMMD5Auth(c.SMTPUser, c.SMTPPass)
+	default:
+		return t, fmt.Errorf("smtp auth must be \"none\", \"plain\", \"login\" or \"cram-md5\", got %q", auth)
+	}
+
+	return t, nil
+}
+
+func (t smtpTransport) Send(from string, to []string, msg []byte) error {
+	addr := net.JoinHostPort(t.Host, t.Port)
+
+	conn, err := (&net.Dialer{Timeout: t.Timeout}).Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		conn.Close()
+	}()
+
+	if err := conn.SetDeadline(time.Now().Add(t.Timeout)); err != nil {
+		return err
+	}
+
+	t.trace("connected to %s\n", addr)
+
+	// Whether the connection is encrypted, which PLAIN and LOGIN
+	// authentication require
+	secure := false
+
+	if t.Security == "tls" {
+		tlsConn := tls.Client(conn, t.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake with %s: %w", addr, err)
+		}
+		conn = tlsConn
+		t.traceTLS(tlsConn)
+		secure = true
+	}
+
+	s := smtpSession{text: textproto.NewConn(conn), trace: t.trace}
+
+	if _, err := s.response(220); err != nil {
+		return err
+	}
+
+	ext, err := s.hello(t.HELO)
+	if err != nil {
+		return err
+	}
+
+	_, offersSTARTTLS := ext["STARTTLS"]
+	if t.Security == "starttls" && !offersSTARTTLS {
+		return fmt.Errorf("%s does not offer STARTTLS", addr)
+	}
+
+	if t.Security == "starttls" || t.Security == "auto" && offersSTARTTLS {
+		if _, err := s.cmd(220, "STARTTLS"); err != nil {
+			return err
+		}
+
+		tlsConn := tls.Client(conn, t.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake with %s: %w", addr, err)
+		}
+		conn = tlsConn
+		t.traceTLS(tlsConn)
+
+		s.text = textproto.NewConn(conn)
+		secure = true
+
+		if ext, err = s.hello(t.HELO); err != nil {
+			return err
+		}
+	}
+
+	if t.Auth != nil {
+		mechanisms, ok := ext["AUTH"]
+		if !ok {
+			return fmt.Errorf("%s does not offer AUTH", addr)
+		}
+
+		if err := s.auth(t.Auth, &smtp.ServerInfo{
+			Name: t.Host,
+			TLS:  secure,
+			Auth: strings.Fields(mechanisms),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.cmd(250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if _, err := s.cmd(25, "RCPT TO:<%s>", rcpt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.cmd(354, "DATA"); err != nil {
+		return err
+	}
+
+	w := s.text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	t.trace("C: <message, %d bytes>\n", len(msg))
+
+	if _, err := s.response(250); err != nil {
+		return err
+	}
+
+	// The mail is accepted, errors on QUIT do not matter
+	s.cmd(221, "QUIT")
+
+	return nil
+}
+
+func (t smtpTransport) trace(format string, args ...any) {
+	if t.Trace != nil {
+		fmt.Fprintf(t.Trace, format, args...)
+	}
+}
+
+func (t smtpTransport) traceTLS(conn *tls.Conn) {
+	state := conn.ConnectionState()
+	t.trace("TLS established: %s, %s\n", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+}
+
+// smtpSession is the command/response part of an SMTP connection
+type smtpSession struct {
+	text  *textproto.Conn
+	trace func(format string, args ...any)
+}
+
+// cmd sends a command and reads the response, which must have the code
+// `expect` (see `textproto.Reader.ReadResponse`)
+func (s smtpSession) cmd(expect int, format string, args ...any) (string, error) {
+	line := fmt.Sprintf(format, args...)
+	s.trace("C: %s\n", line)
+	return s.send(expect, line)
+}
+
+func (s smtpSession) send(expect int, line string) (string, error) {
+	id, err := s.text.Cmd("%s", line)
+	if err != nil {
+		return "", err
+	}
+
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+
+	return s.response(expect)
+}
+
+func (s smtpSession) response(expect int) (string, error) {
+	code, msg, err := s.text.ReadResponse(expect)
+	if code != 0 {
+		for _, line := range strings.Split(msg, "\n") {
+			s.trace("S: %d %s\n", code, line)
+		}
+	}
+	return msg, err
+}
+
+// hello sends EHLO and returns the extensions offered by the server
+func (s smtpSession) hello(name string) (map[string]string, error) {
+	if name == "" {
+		name = "localhost"
+	}
+
+	msg, err := s.cmd(250, "EHLO %s", name)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := make(map[string]string)
+
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, " ")
+		ext[strings.ToUpper(k)] = v
+	}
+
+	return ext, nil
+}
+
+// auth authenticates like `smtp.Client.Auth`, without tracing the
+// credentials
+func (s smtpSession) auth(a smtp.Auth, info *smtp.ServerInfo) error {
+	mech, resp, err := a.Start(info)
+	if err != nil {
+		return err
+	}
+
+	if len(resp) > 0 {
+		s.trace("C: AUTH %s ***\n", mech)
+	} else {
+		s.trace("C: AUTH %s\n", mech)
+	}
+
+	code, msg64, err := s.authCmd(strings.TrimSpace("AUTH " + mech + " " + base64.StdEncoding.EncodeToString(resp)))
+	for err == nil {
+		var msg []byte
+
+		switch code {
+		case 334:
+			msg, err = base64.StdEncoding.DecodeString(msg64)
+		case 235:
+			msg = []byte(msg64)
+		default:
+			err = &textproto.Error{Code: code, Msg: msg64}
+		}
+
+		if err == nil {
+			resp, err = a.Next(msg, code == 334)
+		}
+		if err != nil {
+			// Abort the authentication
+			s.cmd(501, "*")
+			break
+		}
+		if resp == nil {
+			break
+		}
+
+		s.trace("C: ***\n")
+		code, msg64, err = s.authCmd(base64.StdEncoding.EncodeToString(resp))
+	}
+
+	return err
+}
+
+func (s smtpSession) authCmd(line string) (int, string, error) {
+	id, err := s.text.Cmd("%s", line)
+	if err != nil {
+		return 0, "", err
+	}
+
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+
+	code, msg, err := s.text.ReadResponse(0)
+	s.trace("S: %d %s\n", code, msg)
+	return code, msg, err
+}
+
+// loginAuth implements the non-standard but common LOGIN mechanism
+type loginAuth struct {
+	username, password string
+}
+
+func (a loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
@@ -2,18 +2,243 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	texttemplate "text/template"
+	"time"
 )
 
 //go:embed assets/*
 var assets embed.FS
 
-var tmpl = template.Must(template.New("").Funcs(
-	template.FuncMap{
+var (
+	// tmpl holds the page templates and partials for each language
+	tmpl map[string]*template.Template
+
+	// mailTmpl holds the plain text mail templates, which must not be
+	// HTML escaped like the templates in `tmpl`
+	mailTmpl *texttemplate.Template
+
+	// mailHeaders holds the headers of each mail template, e.g.
+	// "Subject"; each header value is a template of its own named
+	// "<mail template>#<header>", see `composeMail`
+	mailHeaders map[string][]string
+)
+
+// The templates executed by the handlers, checked by `loadTemplates`
+var (
+	pageTemplates = []string{
+		"index", "posting", "form", "reject", "error404", "error429",
+		"admin", "admin-login", "admin-outbox",
+		"feed-item", "mail-html",
+	}
+	mailTemplates = []string{
+		"mail-admin.tmpl", "mail-user-whitelisted.tmpl", "mail-user-unknown.tmpl",
+		"mail-user-rejected.tmpl", "mail-user-reverify.tmpl", "mail-user-expiry.tmpl",
+		"mail-test.tmpl", "mail-contact.tmpl", "mail-contact-confirm.tmpl",
+	}
+
+	// The mails to authors, sent in the language of the posting, and to
+	// senders of contact messages, sent in their language; all other
+	// mails go to the admins in the default language
+	userMailTemplates = []string{
+		"mail-user-whitelisted.tmpl", "mail-user-unknown.tmpl", "mail-user-rejected.tmpl",
+		"mail-user-reverify.tmpl", "mail-user-expiry.tmpl", "mail-contact.tmpl",
+		"mail-contact-confirm.tmpl",
+	}
+)
+
+// loadTemplates parses the embedded templates; files in `dir` replace
+// the embedded files of the same name or add further partials
+func loadTemplates(dir string) error {
+	pages, err := readTemplates(dir, "*.html")
+	if err != nil {
+		return err
+	}
+
+	t := template.New("").Funcs(template.FuncMap{
+		"excerpt":        excerpt,
+		"formatDate":     formatDate,
+		"formatSize":     formatSize,
+		"highlight":      highlight,
+		"markdown":       markdown,
 		"mod":            mod,
+		"plainText":      plainText,
 		"replaceNewline": replaceNewline,
-	}).ParseFS(assets, "assets/*"))
+		"t":              func(msg string, args ...any) string { return msg },
+	})
+
+	for _, f := range pages {
+		if _, err := t.New(f.Name).Parse(f.Text); err != nil {
+			return fmt.Errorf("failed to parse template %q: %w", f.Path, err)
+		}
+	}
+
+	for _, name := range pageTemplates {
+		if t.Lookup(name) == nil {
+			return fmt.Errorf("template %q is not defined", name)
+		}
+	}
+
+	// Each language gets its own copy of the templates with `t`
+	// translating into that language
+	byLang := make(map[string]*template.Template)
+
+	for _, lang := range languages {
+		lt, err := t.Clone()
+		if err != nil {
+			return err
+		}
+
+		lt.Funcs(template.FuncMap{
+			"t": func(msg string, args ...any) string { return tr(lang, msg, args...) },
+		})
+
+		for _, name := range pageTemplates {
+			// Executing escapes the template and all templates it
+			// calls, which fails if one is missing; other errors are
+			// due to the missing data
+			var escapeErr *template.Error
+			if err := lt.ExecuteTemplate(io.Discard, name, nil); errors.As(err, &escapeErr) {
+				return fmt.Errorf("template %q: %w", name, err)
+			}
+		}
+
+		byLang[lang] = lt
+	}
+
+	mails, err := readTemplates(dir, "mail-*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	mt := texttemplate.New("")
+	headers := make(map[string][]string)
+
+	for _, f := range mails {
+		// The headers are separated from the text by an empty line and
+		// parsed line by line, so values with line breaks, e.g. titles,
+		// cannot add headers
+		header, text, ok := strings.Cut(strings.ReplaceAll(f.Text, "\r\n", "\n"), "\n\n")
+		if !ok {
+			return fmt.Errorf("mail template %q: missing empty line after the headers", f.Path)
+		}
+
+		if _, err := mt.New(f.Name).Parse(text); err != nil {
+			return fmt.Errorf("failed to parse mail template %q: %w", f.Path, err)
+		}
+
+		for _, line := range strings.Split(header, "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return fmt.Errorf("mail template %q: invalid header line %q", f.Path, line)
+			}
+			key = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key))
+
+			if _, err := mt.New(f.Name + "#" + key).Parse(value); err != nil {
+				return fmt.Errorf("failed to parse header %q of mail template %q: %w", key, f.Path, err)
+			}
+			headers[f.Name] = append(headers[f.Name], key)
+		}
+
+		if mt.Lookup(f.Name+"#Subject") == nil {
+			return fmt.Errorf("mail template %q: missing Subject header", f.Path)
+		}
+	}
+
+	for _, name := range mailTemplates {
+		if mt.Lookup(name) == nil {
+			return fmt.Errorf("mail template %q is not defined", name)
+		}
+	}
+
+	for _, lang := range languages[1:] {
+		for _, name := range userMailTemplates {
+			if mt.Lookup(mailTemplateName(name, lang)) == nil {
+				log.Printf("mail template %q is not translated to %q, using %q\n", name, lang, defaultLanguage)
+			}
+		}
+	}
+
+	tmpl, mailTmpl, mailHeaders = byLang, mt, headers
+
+	return nil
+}
+
+// mailTemplateName returns the name of the mail template `name` in
+// lang, e.g. "mail-user-expiry.en.tmpl"
+func mailTemplateName(name, lang string) string {
+	if lang == defaultLanguage {
+		return name
+	}
+	return strings.TrimSuffix(name, ".tmpl") + "." + lang + ".tmpl"
+}
+
+type templateFile struct {
+	Name string
+	Path string
+	Text string
+}
+
+// readTemplates returns the embedded template files matching pattern,
+// replaced by or added to from `dir`, sorted by name
+func readTemplates(dir, pattern string) ([]templateFile, error) {
+	files := make(map[string]templateFile)
+
+	names, err := fs.Glob(assets, "assets/"+pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		data, err := assets.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Base(name)] = templateFile{Name: path.Base(name), Path: name, Text: string(data)}
+	}
+
+	if dir != "" {
+		names, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+
+			base := filepath.Base(name)
+			if _, ok := files[base]; ok {
+				log.Printf("template %q overridden by %q\n", base, name)
+			}
+			files[base] = templateFile{Name: base, Path: name, Text: string(data)}
+		}
+	}
+
+	var result []templateFile
+	for _, f := range files {
+		result = append(result, f)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
 
 func mod(a, b int) int {
 	return a % b
@@ -22,3 +247,23 @@ func mod(a, b int) int {
 func replaceNewline(s string) template.HTML {
 	return template.HTML(strings.Replace(template.HTMLEscapeString(s), "\n", "<br>", -1))
 }
+
+// formatDate formats a date given as yyyy-mm-dd as dd.mm.yyyy
+func formatDate(s string) string {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return s
+	}
+	return t.Format("02.01.2006")
+}
+
+// formatSize formats a number of bytes, e.g. "1.5 MB"
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
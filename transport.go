@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// mailTransport delivers a rendered mail to its recipients
+type mailTransport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// transport is the mail transport selected with `mail_transport`
+var transport mailTransport
+
+// newMailTransport returns the mail transport selected in the config
+func newMailTransport(c Config) (mailTransport, error) {
+	switch c.MailTransport {
+	case "smtp":
+		t, err := newSMTPTransport(c)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case "sendmail":
+		return sendmailTransport{Path: c.SendmailPath}, nil
+	case "file":
+		if err := os.MkdirAll(c.MailDir, 0o750); err != nil {
+			return nil, err
+		}
+		return fileTransport{Dir: c.MailDir}, nil
+	case "log":
+		return logTransport{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q, must be \"smtp\", \"sendmail\", \"file\" or \"log\"", c.MailTransport)
+}
+
+// sendmailTransport pipes mails to a local sendmail compatible binary
+type sendmailTransport struct {
+	Path string
+}
+
+func (t sendmailTransport) Send(from string, to []string, msg []byte) error {
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(t.Path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return fmt.Errorf("%s: %w: %s", t.Path, err, s)
+		}
+		return fmt.Errorf("%s: %w", t.Path, err)
+	}
+
+	return nil
+}
+
+// fileTransport writes every mail as `.eml` file into a directory; if
+// the directory is a Maildir (has `tmp` and `new`), the mail is written
+// to `tmp` and then moved to `new` so mail clients can read it
+type fileTransport struct {
+	Dir string
+}
+
+// fileTransportSeq makes the file names unique within the process
+var fileTransportSeq atomic.Uint64
+
+func (t fileTransport) Send(from string, to []string, msg []byte) error {
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s.eml", time.Now().Unix(), time.Now().Nanosecond()/1000,
+		os.Getpid(), fileTransportSeq.Add(1), strings.ReplaceAll(hostname, "/", "_"))
+
+	tmpDir, newDir := t.Dir, t.Dir
+	if isDir(filepath.Join(t.Dir, "tmp")) && isDir(filepath.Join(t.Dir, "new")) {
+		tmpDir, newDir = filepath.Join(t.Dir, "tmp"), filepath.Join(t.Dir, "new")
+	}
+
+	// Record the envelope, which is not part of the message
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "X-Envelope-From: %s\r\n", from)
+	fmt.Fprintf(&buf, "X-Envelope-To: %s\r\n", strings.Join(to, ", "))
+	buf.Write(msg)
+
+	tmpPath := filepath.Join(tmpDir, "."+name)
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(newDir, name))
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// logTransport only writes mails to the log, e.g. for development
+type logTransport struct{}
+
+func (logTransport) Send(from string, to []string, msg []byte) error {
+	log.Printf("mail from %q to %q:\n%s\n", from, to, msg)
+	return nil
+}